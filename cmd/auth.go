@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stormcat24/protodep/pkg/resolver"
+)
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Inspect the credentials used to fetch dependencies",
+}
+
+var authStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the credentials used per host and check they grant access to the dependencies",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		probe, err := cmd.Flags().GetBool("probe")
+		if err != nil {
+			return err
+		}
+
+		conf, err := newResolverConfig(cmd)
+		if err != nil {
+			return err
+		}
+		service, err := resolver.New(conf)
+		if err != nil {
+			return err
+		}
+
+		statuses, err := service.AuthStatus(probe)
+		if err != nil {
+			return err
+		}
+		if len(statuses) == 0 {
+			fmt.Println("no dependencies nor sessions found")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tPROTOCOL\tSOURCE\tUSER\tSECRET\tACCESS")
+		failed := false
+		for _, status := range statuses {
+			access := "not checked"
+			switch {
+			case status.AuthErr != nil:
+				access = "error: " + status.AuthErr.Error()
+				failed = true
+			case status.TokenErr != nil:
+				access = "invalid token: " + status.TokenErr.Error()
+				failed = true
+			}
+			if status.Probed {
+				if status.ProbeErr != nil {
+					access = fmt.Sprintf("failed on %s: %v", status.Repository, status.ProbeErr)
+					failed = true
+				} else if status.TokenErr == nil {
+					access = "ok (" + status.Repository + ")"
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				status.Host, status.Protocol, status.Source, valueOrDash(status.Username), valueOrDash(status.Secret), access)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if failed {
+			return errors.New("some credentials are invalid or don't grant access")
+		}
+		return nil
+	},
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func initAuthCmd() {
+	authStatusCmd.Flags().Bool("probe", true, "list the references of a dependency of each host, like git ls-remote, to check access")
+	addResolverFlags(authStatusCmd)
+	authCmd.AddCommand(authStatusCmd)
+}
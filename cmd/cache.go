@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+
+	"github.com/stormcat24/protodep/pkg/cache"
+	"github.com/stormcat24/protodep/pkg/logger"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and maintain the local cache of dependency repositories",
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List cached repositories with their size and last use",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := openCache(cmd)
+		if err != nil {
+			return err
+		}
+
+		repos, err := c.List()
+		if err != nil {
+			return err
+		}
+		if len(repos) == 0 {
+			logger.Info("cache %s is empty", c.Dir())
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "REPOSITORY\tSIZE\tLAST USED")
+		var total int64
+		for _, repo := range repos {
+			total += repo.Size
+			fmt.Fprintf(w, "%s\t%s\t%s\n", repo.Name, formatSize(repo.Size), repo.LastUsed.Format(time.RFC3339))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		logger.Info("%d repositories, %s in %s", len(repos), formatSize(total), c.Dir())
+		return nil
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cached repositories not used for a while",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := openCache(cmd)
+		if err != nil {
+			return err
+		}
+
+		olderThanValue, err := cmd.Flags().GetString("older-than")
+		if err != nil {
+			return err
+		}
+		olderThan, err := parseAge(olderThanValue)
+		if err != nil {
+			return err
+		}
+
+		pruned, err := c.Prune(olderThan)
+		for _, repo := range pruned {
+			logger.Info("removed %s (%s, last used %s)", repo.Name, formatSize(repo.Size), repo.LastUsed.Format(time.RFC3339))
+		}
+		if err != nil {
+			return err
+		}
+		logger.Info("pruned %d repositories", len(pruned))
+		return nil
+	},
+}
+
+var cacheRemoveCmd = &cobra.Command{
+	Use:   "remove <repo>...",
+	Short: "Remove repositories from the cache, e.g. github.com/stormcat24/protodep",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := openCache(cmd)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range args {
+			if err := c.Remove(strings.TrimSuffix(name, "/")); err != nil {
+				return err
+			}
+			logger.Info("removed %s", name)
+		}
+		return nil
+	},
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the integrity of every cached repository",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := openCache(cmd)
+		if err != nil {
+			return err
+		}
+
+		results, err := c.Verify()
+		if err != nil {
+			return err
+		}
+
+		broken := 0
+		for _, result := range results {
+			if result.Err != nil {
+				broken++
+				logger.Error("%s: %v", result.Repository.Name, result.Err)
+			} else {
+				logger.Info("%s: ok (%d objects)", result.Repository.Name, result.Objects)
+			}
+		}
+		if broken > 0 {
+			return fmt.Errorf("%d of %d cached repositories are broken, remove them with 'protodep cache remove'", broken, len(results))
+		}
+		return nil
+	},
+}
+
+func initCacheCmd() {
+	cachePruneCmd.Flags().String("older-than", "30d", "remove repositories not used within this duration (e.g. 72h, 30d)")
+	cacheCmd.AddCommand(cacheListCmd, cachePruneCmd, cacheRemoveCmd, cacheVerifyCmd)
+}
+
+// cacheDir resolves the cache location from --cache-dir, the environment and the home directory.
+func cacheDir(cmd *cobra.Command) (string, error) {
+	homeDir, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	override, err := cmd.Flags().GetString("cache-dir")
+	if err != nil {
+		return "", err
+	}
+	return cache.Dir(homeDir, override), nil
+}
+
+func openCache(cmd *cobra.Command) (cache.Cache, error) {
+	dir, err := cacheDir(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return cache.New(dir), nil
+}
+
+// parseAge is time.ParseDuration with additional support for a days suffix, e.g. "30d".
+func parseAge(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, errors.New("duration must not be negative")
+	}
+	return d, nil
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
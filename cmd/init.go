@@ -1,6 +1,9 @@
 package cmd
 
 func init() {
-	RootCmd.AddCommand(upCmd, versionCmd, loginCmd, logoutCmd)
+	RootCmd.AddCommand(upCmd, unpatchCmd, versionCmd, loginCmd, logoutCmd, cacheCmd, authCmd)
 	initDepCmd()
+	initCacheCmd()
+	initSessionCmd()
+	initAuthCmd()
 }
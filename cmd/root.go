@@ -50,6 +50,7 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	RootCmd.PersistentFlags().String("cache-dir", "", "directory for cached dependency repositories (default $PROTODEP_CACHE_DIR, ~/.protodep of older versions, $XDG_CACHE_HOME/protodep or ~/.cache/protodep)")
 }
 
 func initConfig() {
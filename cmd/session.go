@@ -1,6 +1,10 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"io"
+
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 
@@ -9,32 +13,89 @@ import (
 
 var loginCmd = &cobra.Command{
 	Use:   "login",
-	Short: "login to github with a personal-access-token",
+	Short: "login to a git host (github.com by default) with a personal-access-token",
 	RunE: func(cdm *cobra.Command, args []string) error {
 		homeDir, err := homedir.Dir()
 		if err != nil {
 			return err
 		}
 
+		host, err := cdm.Flags().GetString("host")
+		if err != nil {
+			return err
+		}
+
+		flag, err := cdm.Flags().GetString("storage")
+		if err != nil {
+			return err
+		}
+		storage, err := session.ParseStorage(flag)
+		if err != nil {
+			return err
+		}
+
+		username, err := cdm.Flags().GetString("username")
+		if err != nil {
+			return err
+		}
+
+		withToken, err := cdm.Flags().GetBool("with-token")
+		if err != nil {
+			return err
+		}
+		if withToken && username == "" {
+			return errors.New("--with-token requires --username")
+		}
+
 		var sessionService = session.New(&session.Config{
 			HomeDir: homeDir,
+			Storage: storage,
 		})
-		return sessionService.Login()
+		if withToken {
+			token, err := io.ReadAll(cdm.InOrStdin())
+			if err != nil {
+				return fmt.Errorf("read token from stdin: %w", err)
+			}
+			return sessionService.LoginWithToken(host, username, string(token))
+		}
+		return sessionService.Login(host)
 	},
 }
 
 var logoutCmd = &cobra.Command{
 	Use:   "logout",
-	Short: "logout from github",
+	Short: "logout from a git host (github.com by default)",
 	RunE: func(cdm *cobra.Command, args []string) error {
 		homeDir, err := homedir.Dir()
 		if err != nil {
 			return err
 		}
 
+		host, err := cdm.Flags().GetString("host")
+		if err != nil {
+			return err
+		}
+
+		all, err := cdm.Flags().GetBool("all")
+		if err != nil {
+			return err
+		}
+
 		var sessionService = session.New(&session.Config{
 			HomeDir: homeDir,
 		})
-		return sessionService.Logout()
+		if all {
+			return sessionService.LogoutAll()
+		}
+		return sessionService.Logout(host)
 	},
 }
+
+func initSessionCmd() {
+	loginCmd.Flags().String("host", session.DefaultHost, "git host to login to, e.g. a GitHub Enterprise, GitLab or Gitea server")
+	loginCmd.Flags().String("username", "", "username of the session, required with --with-token")
+	loginCmd.Flags().Bool("with-token", false, "read the token from stdin instead of prompting, for scripts and CI")
+	loginCmd.Flags().String("storage", string(session.StorageAuto), "where to store the token: auto (system keyring when available), keyring or file (encrypted)")
+	logoutCmd.Flags().String("host", session.DefaultHost, "git host to logout from")
+	logoutCmd.Flags().Bool("all", false, "logout from every host")
+}
@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/stormcat24/protodep/pkg/resolver"
+)
+
+var unpatchCmd = &cobra.Command{
+	Use:   "unpatch",
+	Short: "Restore the upstream packages, imports and type names of .proto vendors patched by smart-patch",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		return resolver.Unpatch(pwd, pwd)
+	},
+}
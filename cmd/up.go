@@ -8,6 +8,7 @@ import (
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 
+	"github.com/stormcat24/protodep/pkg/config"
 	"github.com/stormcat24/protodep/pkg/logger"
 	"github.com/stormcat24/protodep/pkg/resolver"
 )
@@ -16,29 +17,6 @@ var upCmd = &cobra.Command{
 	Use:   "up",
 	Short: "Populate .proto vendors existing protodep.toml and lock",
 	RunE: func(cmd *cobra.Command, args []string) error {
-
-		homeDir, err := homedir.Dir()
-		if err != nil {
-			return err
-		}
-
-		var sessionService = session.New(&session.Config{
-			HomeDir: homeDir,
-		})
-
-		hasSession := len(sessionService.GetUser()) > 0 && len(sessionService.GetToken()) > 0
-		if hasSession {
-			logger.Info("using session credentials for user: %s", sessionService.GetUser())
-		}
-
-		/*	useHttps = true
-			if basicAuthUsername == "" {
-
-			}
-			if basicAuthPassword == "" {
-				basicAuthPassword = sessionService.GetToken()
-			}
-		*/
 		isForceUpdate, err := cmd.Flags().GetBool("force")
 		if err != nil {
 			return err
@@ -51,68 +29,12 @@ var upCmd = &cobra.Command{
 		}
 		logger.Info("cleanup cache = %t", isCleanupCache)
 
-		identityFile, err := cmd.Flags().GetString("identity-file")
+		conf, err := newResolverConfig(cmd)
 		if err != nil {
 			return err
 		}
-		logger.Info("identity file = %s", identityFile)
 
-		password, err := cmd.Flags().GetString("password")
-		if err != nil {
-			return err
-		}
-		if password != "" {
-			logger.Info("password = %s", strings.Repeat("x", len(password))) // Do not display the password.
-		}
-
-		useHttps, err := cmd.Flags().GetBool("use-https")
-		if err != nil {
-			return err
-		}
-		if hasSession {
-			useHttps = true
-		}
-		logger.Info("use https = %t", useHttps)
-
-		basicAuthUsername, err := cmd.Flags().GetString("basic-auth-username")
-		if err != nil {
-			return err
-		}
-		if hasSession && basicAuthUsername == "" {
-			basicAuthUsername = sessionService.GetUser()
-		}
-		if basicAuthUsername != "" {
-			logger.Info("https basic auth username = %s", basicAuthUsername)
-		}
-
-		basicAuthPassword, err := cmd.Flags().GetString("basic-auth-password")
-		if err != nil {
-			return err
-		}
-		if hasSession && basicAuthPassword == "" {
-			basicAuthPassword = sessionService.GetToken()
-		}
-		if basicAuthPassword != "" {
-			logger.Info("https basic auth password = %s", strings.Repeat("x", len(basicAuthPassword))) // Do not display the password.
-		}
-
-		pwd, err := os.Getwd()
-		if err != nil {
-			return err
-		}
-
-		conf := resolver.Config{
-			UseHttps:          useHttps,
-			HomeDir:           homeDir,
-			TargetDir:         pwd,
-			OutputDir:         pwd,
-			BasicAuthUsername: basicAuthUsername,
-			BasicAuthPassword: basicAuthPassword,
-			IdentityFile:      identityFile,
-			IdentityPassword:  password,
-		}
-
-		updateService, err := resolver.New(&conf)
+		updateService, err := resolver.New(conf)
 		if err != nil {
 			return err
 		}
@@ -121,12 +43,125 @@ var upCmd = &cobra.Command{
 	},
 }
 
+// newResolverConfig builds the resolver configuration from the flags added by addResolverFlags, the sessions and the user config.
+func newResolverConfig(cmd *cobra.Command) (*resolver.Config, error) {
+	homeDir, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionService = session.New(&session.Config{
+		HomeDir: homeDir,
+	})
+
+	sessions := make(map[string]config.Credential)
+	for _, host := range sessionService.Hosts() {
+		logger.Info("using session credentials of %s for user: %s", host, sessionService.GetUser(host))
+		sessions[host] = config.Credential{
+			Username: sessionService.GetUser(host),
+			Password: sessionService.GetToken(host),
+		}
+	}
+
+	identityFile, err := cmd.Flags().GetString("identity-file")
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("identity file = %s", identityFile)
+
+	password, err := cmd.Flags().GetString("password")
+	if err != nil {
+		return nil, err
+	}
+	if password != "" {
+		logger.Info("password = %s", strings.Repeat("x", len(password))) // Do not display the password.
+	}
+
+	useHttps, err := cmd.Flags().GetBool("use-https")
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("use https = %t", useHttps)
+
+	basicAuthUsername, err := cmd.Flags().GetString("basic-auth-username")
+	if err != nil {
+		return nil, err
+	}
+	if basicAuthUsername != "" {
+		logger.Info("https basic auth username = %s", basicAuthUsername)
+	}
+
+	basicAuthPassword, err := cmd.Flags().GetString("basic-auth-password")
+	if err != nil {
+		return nil, err
+	}
+	if basicAuthPassword != "" {
+		logger.Info("https basic auth password = %s", strings.Repeat("x", len(basicAuthPassword))) // Do not display the password.
+	}
+
+	userConf, err := config.LoadUserConfig(config.UserConfigPath(homeDir))
+	if err != nil {
+		return nil, err
+	}
+
+	caBundle, err := cmd.Flags().GetString("ca-bundle")
+	if err != nil {
+		return nil, err
+	}
+	if caBundle == "" {
+		caBundle = userConf.CABundle
+	}
+	if caBundle != "" {
+		logger.Info("ca bundle = %s", caBundle)
+	}
+
+	insecureSkipTLS, err := cmd.Flags().GetBool("insecure-skip-tls-verify")
+	if err != nil {
+		return nil, err
+	}
+
+	cacheDir, err := cacheDir(cmd)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("cache dir = %s", cacheDir)
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return &resolver.Config{
+		UseHttps:          useHttps,
+		HomeDir:           homeDir,
+		CacheDir:          cacheDir,
+		TargetDir:         pwd,
+		OutputDir:         pwd,
+		BasicAuthUsername: basicAuthUsername,
+		BasicAuthPassword: basicAuthPassword,
+		Sessions:          sessions,
+		IdentityFile:      identityFile,
+		IdentityPassword:  password,
+		CABundle:          caBundle,
+		InsecureSkipTLS:   insecureSkipTLS,
+		Credentials:       userConf.Credentials,
+		URLRewrites:       userConf.URLRewrites,
+	}, nil
+}
+
+// addResolverFlags adds the flags selecting how dependencies are fetched.
+func addResolverFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringP("identity-file", "i", "", "set the identity file for SSH")
+	cmd.PersistentFlags().StringP("password", "p", "", "set the password for SSH")
+	cmd.PersistentFlags().BoolP("use-https", "u", false, "use HTTPS to get dependencies.")
+	cmd.PersistentFlags().StringP("basic-auth-username", "", "", "set the username with Basic Auth via HTTPS")
+	cmd.PersistentFlags().StringP("basic-auth-password", "", "", "set the password or personal access token(when enabled 2FA) with Basic Auth via HTTPS")
+	cmd.PersistentFlags().StringP("ca-bundle", "", "", "set a PEM file of additional CA certificates trusted via HTTPS")
+	cmd.PersistentFlags().BoolP("insecure-skip-tls-verify", "", false, "skip verification of server certificates via HTTPS (insecure!)")
+}
+
 func initDepCmd() {
 	upCmd.PersistentFlags().BoolP("force", "f", false, "update locked file and .proto vendors")
-	upCmd.PersistentFlags().StringP("identity-file", "i", "", "set the identity file for SSH")
-	upCmd.PersistentFlags().StringP("password", "p", "", "set the password for SSH")
 	upCmd.PersistentFlags().BoolP("cleanup", "c", false, "cleanup cache before exec.")
-	upCmd.PersistentFlags().BoolP("use-https", "u", false, "use HTTPS to get dependencies.")
-	upCmd.PersistentFlags().StringP("basic-auth-username", "", "", "set the username with Basic Auth via HTTPS")
-	upCmd.PersistentFlags().StringP("basic-auth-password", "", "", "set the password or personal access token(when enabled 2FA) with Basic Auth via HTTPS")
+	addResolverFlags(upCmd)
 }
@@ -6,21 +6,32 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 type authMethod string
 
 const (
-	SSHAgent authMethod = "SSHAgent"
-	SSH                 = "SSH"
-	HTTPS               = "HTTPS"
+	SSHAgent         authMethod = "SSHAgent"
+	SSH                         = "SSH"
+	HTTPS                       = "HTTPS"
+	Netrc                       = "Netrc"
+	CredentialHelper            = "CredentialHelper"
+	GitHubAppMethod             = "GitHubApp"
 )
 
 type authOptions struct {
-	method  authMethod
-	pemFile string
-	username string
-	password string
+	method    authMethod
+	pemFile   string
+	username  string
+	password  string
+	netrcFile string
+	reponame  string
+
+	knownHosts    string
+	hostKeyPolicy HostKeyPolicy
+
+	githubApp GitHubApp
 }
 
 type funcAuthOption struct {
@@ -43,9 +54,17 @@ type AuthProvider interface {
 type AuthProviderWithSSH struct {
 	pemFile  string
 	password string
+	hostKeys hostKeyOptions
 }
 
 type AuthProviderWithSSHAgent struct {
+	hostKeys hostKeyOptions
+}
+
+// hostKeyOptions select the known_hosts file ssh host keys are checked against, go-git's default when empty.
+type hostKeyOptions struct {
+	knownHosts string
+	policy     HostKeyPolicy
 }
 
 type AuthProviderHTTPS struct {
@@ -73,6 +92,37 @@ func WithPemFile(pemFile, password string) AuthOption {
 	}
 }
 
+// WithKnownHosts checks ssh host keys against knownHosts with policy, instead of the default known_hosts files.
+func WithKnownHosts(knownHosts string, policy HostKeyPolicy) AuthOption {
+	return &funcAuthOption{
+		f: func(options *authOptions) {
+			options.knownHosts = knownHosts
+			options.hostKeyPolicy = policy
+		},
+	}
+}
+
+// WithNetrc looks up the HTTPS credentials of the repository host in a .netrc file.
+func WithNetrc(netrcFile, reponame string) AuthOption {
+	return &funcAuthOption{
+		f: func(options *authOptions) {
+			options.method = Netrc
+			options.netrcFile = netrcFile
+			options.reponame = reponame
+		},
+	}
+}
+
+// WithCredentialHelper asks `git credential fill` for the HTTPS credentials of the repository.
+func WithCredentialHelper(reponame string) AuthOption {
+	return &funcAuthOption{
+		f: func(options *authOptions) {
+			options.method = CredentialHelper
+			options.reponame = reponame
+		},
+	}
+}
+
 func NewAuthProvider(opt ...AuthOption) AuthProvider {
 	opts := authOptions{
 		method: SSHAgent,
@@ -82,12 +132,30 @@ func NewAuthProvider(opt ...AuthOption) AuthProvider {
 	}
 
 	var authProvider AuthProvider
+	hostKeys := hostKeyOptions{knownHosts: opts.knownHosts, policy: opts.hostKeyPolicy}
 	if opts.method == SSHAgent {
-		authProvider = &AuthProviderWithSSHAgent{}
+		authProvider = &AuthProviderWithSSHAgent{
+			hostKeys: hostKeys,
+		}
 	} else if opts.method == SSH {
 		authProvider = &AuthProviderWithSSH{
 			pemFile:  opts.pemFile,
 			password: opts.password,
+			hostKeys: hostKeys,
+		}
+	} else if opts.method == Netrc {
+		authProvider = &AuthProviderWithNetrc{
+			netrcFile: opts.netrcFile,
+			reponame:  opts.reponame,
+		}
+	} else if opts.method == CredentialHelper {
+		authProvider = &AuthProviderWithCredentialHelper{
+			reponame: opts.reponame,
+		}
+	} else if opts.method == GitHubAppMethod {
+		authProvider = &AuthProviderWithGitHubApp{
+			app:      opts.githubApp,
+			reponame: opts.reponame,
 		}
 	} else {
 		authProvider = &AuthProviderHTTPS{
@@ -112,6 +180,10 @@ func (p *AuthProviderWithSSH) AuthMethod() (transport.AuthMethod, error) {
 	if err != nil {
 		return nil, err
 	}
+	am.HostKeyCallback, err = p.hostKeys.callback()
+	if err != nil {
+		return nil, err
+	}
 	return am, nil
 }
 
@@ -126,11 +198,23 @@ func (p *AuthProviderWithSSHAgent) GetRepositoryURL(reponame string) string {
 func (p *AuthProviderWithSSHAgent) AuthMethod() (transport.AuthMethod, error) {
 	aa, err := ssh.NewSSHAgentAuth(ssh.DefaultUsername)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	aa.HostKeyCallback, err = p.hostKeys.callback()
+	if err != nil {
+		return nil, err
 	}
 	return aa, nil
 }
 
+// callback returns nil to keep go-git's default, $SSH_KNOWN_HOSTS or ~/.ssh/known_hosts checked strictly.
+func (o hostKeyOptions) callback() (gossh.HostKeyCallback, error) {
+	if o.knownHosts == "" {
+		return nil, nil
+	}
+	return NewHostKeyCallback(o.knownHosts, o.policy)
+}
+
 func (p *AuthProviderHTTPS) GetRepositoryURL(reponame string) string {
 	return fmt.Sprintf("https://%s.git", reponame)
 }
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// AuthProviderWithCredentialHelper authenticates HTTPS repositories with the credentials git's credential helper returns.
+type AuthProviderWithCredentialHelper struct {
+	reponame string
+
+	once       sync.Once
+	authMethod transport.AuthMethod
+}
+
+// HasCredentialHelper reports whether git is installed and a credential helper is configured.
+func HasCredentialHelper() bool {
+	if _, err := exec.LookPath("git"); err != nil {
+		return false
+	}
+	out, err := exec.Command("git", "config", "--get-regexp", `^credential\..*helper$`).Output()
+	return err == nil && strings.TrimSpace(string(out)) != ""
+}
+
+// FillCredential runs `git credential fill` for the https url of reponame and returns the username and password.
+func FillCredential(reponame string) (string, string, error) {
+	host, path, _ := strings.Cut(reponame, "/")
+
+	var input bytes.Buffer
+	fmt.Fprintf(&input, "protocol=https\nhost=%s\n", host)
+	if path != "" {
+		fmt.Fprintf(&input, "path=%s.git\n", path)
+	}
+	input.WriteString("\n")
+
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Stdin = &input
+	// never fall back to an interactive prompt, protodep may run without a terminal
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GCM_INTERACTIVE=never")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("git credential fill for %s: %w", host, err)
+	}
+
+	var username, password string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "username":
+			username = value
+		case "password":
+			password = value
+		}
+	}
+	return username, password, scanner.Err()
+}
+
+func (p *AuthProviderWithCredentialHelper) GetRepositoryURL(reponame string) string {
+	return fmt.Sprintf("https://%s.git", reponame)
+}
+
+func (p *AuthProviderWithCredentialHelper) AuthMethod() (transport.AuthMethod, error) {
+	p.once.Do(func() {
+		username, password, err := FillCredential(p.reponame)
+		if err != nil {
+			// the helper has nothing for this repository, try anonymously like git would
+			return
+		}
+		if username == "" && password == "" {
+			return
+		}
+		p.authMethod = &http.BasicAuth{
+			Username: username,
+			Password: password,
+		}
+	})
+	return p.authMethod, nil
+}
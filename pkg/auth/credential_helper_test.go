@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthProviderWithCredentialHelper(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	gitconfig := filepath.Join(t.TempDir(), "gitconfig")
+	t.Setenv("GIT_CONFIG_GLOBAL", gitconfig)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	require.NoError(t, os.WriteFile(gitconfig, nil, 0600))
+	require.False(t, HasCredentialHelper())
+
+	helper := "[credential]\n\thelper = \"!f() { test \\\"$1\\\" = get && echo username=octocat && echo password=github-token; }; f\"\n"
+	require.NoError(t, os.WriteFile(gitconfig, []byte(helper), 0600))
+	require.True(t, HasCredentialHelper())
+
+	target := NewAuthProvider(WithCredentialHelper("github.com/stormcat24/protodep"))
+	require.Equal(t, "https://github.com/stormcat24/protodep.git", target.GetRepositoryURL("github.com/stormcat24/protodep"))
+
+	am, err := target.AuthMethod()
+	require.NoError(t, err)
+	require.Equal(t, &http.BasicAuth{Username: "octocat", Password: "github-token"}, am)
+}
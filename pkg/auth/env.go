@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"strings"
+)
+
+const (
+	EnvToken    = "PROTODEP_TOKEN"
+	EnvUsername = "PROTODEP_USERNAME"
+
+	// envDefaultUsername is sent with a token when no username is given, most git hosts ignore it.
+	envDefaultUsername = "protodep"
+)
+
+// EnvCredential is a https credential read from environment variables.
+type EnvCredential struct {
+	Username string
+	Password string
+	// Source names the variable the password was read from, never log the password itself.
+	Source string
+}
+
+// HostEnvSuffix turns a host into the suffix of its variables, e.g. github.com becomes GITHUB_COM.
+func HostEnvSuffix(host string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, host)
+}
+
+// LookupEnvCredential returns the credential of host from the environment, in order of precedence:
+// PROTODEP_TOKEN_<HOST>, PROTODEP_TOKEN, GITHUB_TOKEN for github.com and CI_JOB_TOKEN for the GitLab instance running the job.
+func LookupEnvCredential(host string, getenv func(string) string) (*EnvCredential, bool) {
+	suffix := HostEnvSuffix(host)
+
+	username := getenv(EnvUsername + "_" + suffix)
+	if username == "" {
+		username = getenv(EnvUsername)
+	}
+
+	for _, name := range []string{EnvToken + "_" + suffix, EnvToken} {
+		if token := getenv(name); token != "" {
+			if username == "" {
+				username = envDefaultUsername
+			}
+			return &EnvCredential{Username: username, Password: token, Source: name}, true
+		}
+	}
+
+	if host == "github.com" {
+		if token := getenv("GITHUB_TOKEN"); token != "" {
+			return &EnvCredential{Username: "x-access-token", Password: token, Source: "GITHUB_TOKEN"}, true
+		}
+	}
+
+	// GitLab CI exposes a job token valid for the projects of its own instance
+	if token := getenv("CI_JOB_TOKEN"); token != "" && host != "" && getenv("CI_SERVER_HOST") == host {
+		return &EnvCredential{Username: "gitlab-ci-token", Password: token, Source: "CI_JOB_TOKEN"}, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupEnvCredential(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		env      map[string]string
+		expected *EnvCredential
+	}{
+		{
+			name:     "nothing set",
+			host:     "github.com",
+			env:      map[string]string{},
+			expected: nil,
+		},
+		{
+			name:     "host variable wins",
+			host:     "github.com",
+			env:      map[string]string{"PROTODEP_TOKEN_GITHUB_COM": "host-token", "PROTODEP_TOKEN": "token", "GITHUB_TOKEN": "gh"},
+			expected: &EnvCredential{Username: "protodep", Password: "host-token", Source: "PROTODEP_TOKEN_GITHUB_COM"},
+		},
+		{
+			name:     "generic token with username",
+			host:     "git.example-corp.com",
+			env:      map[string]string{"PROTODEP_TOKEN": "token", "PROTODEP_USERNAME": "ci", "PROTODEP_USERNAME_GIT_EXAMPLE_CORP_COM": "corp-ci"},
+			expected: &EnvCredential{Username: "corp-ci", Password: "token", Source: "PROTODEP_TOKEN"},
+		},
+		{
+			name:     "github token only for github.com",
+			host:     "gitlab.com",
+			env:      map[string]string{"GITHUB_TOKEN": "gh"},
+			expected: nil,
+		},
+		{
+			name:     "github token",
+			host:     "github.com",
+			env:      map[string]string{"GITHUB_TOKEN": "gh"},
+			expected: &EnvCredential{Username: "x-access-token", Password: "gh", Source: "GITHUB_TOKEN"},
+		},
+		{
+			name:     "job token of the gitlab instance",
+			host:     "gitlab.example.com",
+			env:      map[string]string{"CI_JOB_TOKEN": "job", "CI_SERVER_HOST": "gitlab.example.com"},
+			expected: &EnvCredential{Username: "gitlab-ci-token", Password: "job", Source: "CI_JOB_TOKEN"},
+		},
+		{
+			name:     "job token of another instance",
+			host:     "gitlab.com",
+			env:      map[string]string{"CI_JOB_TOKEN": "job", "CI_SERVER_HOST": "gitlab.example.com"},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, ok := LookupEnvCredential(tt.host, func(key string) string { return tt.env[key] })
+			require.Equal(t, tt.expected != nil, ok)
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const (
+	EnvGitHubAppID             = "PROTODEP_GITHUB_APP_ID"
+	EnvGitHubAppInstallationID = "PROTODEP_GITHUB_APP_INSTALLATION_ID"
+	EnvGitHubAppPrivateKey     = "PROTODEP_GITHUB_APP_PRIVATE_KEY"
+
+	// githubAppTokenUsername is the username GitHub expects with installation tokens.
+	githubAppTokenUsername = "x-access-token"
+
+	// githubAppTokenMargin renews installation tokens this long before they expire, so a clone never starts with a token about to expire.
+	githubAppTokenMargin = time.Minute
+)
+
+// GitHubApp authenticates as an installation of a GitHub App, instead of a personal access token tied to a user.
+type GitHubApp struct {
+	// AppID is the ID of the app, shown in its settings.
+	AppID string
+	// InstallationID is the installation in the organization owning the repositories.
+	// When zero, it is looked up from the repository.
+	InstallationID int64
+	// PrivateKey is the PEM private key generated in the settings of the app.
+	PrivateKey []byte
+	// APIURL is the REST API root, https://api.github.com by default or https://{host}/api/v3 for GitHub Enterprise Server.
+	APIURL string
+	// Client calls the API, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// AuthProviderWithGitHubApp clones over https with short-lived installation tokens of a GitHub App.
+type AuthProviderWithGitHubApp struct {
+	app      GitHubApp
+	reponame string
+	now      func() time.Time
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// WithGitHubApp authenticates https clones of reponame with installation tokens of app.
+func WithGitHubApp(app GitHubApp, reponame string) AuthOption {
+	return &funcAuthOption{
+		f: func(options *authOptions) {
+			options.method = GitHubAppMethod
+			options.githubApp = app
+			options.reponame = reponame
+		},
+	}
+}
+
+// GitHubAPIURL returns the REST API root of a GitHub host.
+func GitHubAPIURL(host string) string {
+	if host == "github.com" {
+		return "https://api.github.com"
+	}
+	return fmt.Sprintf("https://%s/api/v3", host)
+}
+
+// LookupEnvGitHubApp returns the GitHub App configured by environment variables, for github.com.
+// The private key is the PEM content itself, as CI secrets usually are.
+func LookupEnvGitHubApp(host string, getenv func(string) string) (*GitHubApp, error) {
+	appID := getenv(EnvGitHubAppID)
+	if appID == "" || host != "github.com" {
+		return nil, nil
+	}
+
+	privateKey := getenv(EnvGitHubAppPrivateKey)
+	if privateKey == "" {
+		return nil, fmt.Errorf("$%s is set but $%s is empty", EnvGitHubAppID, EnvGitHubAppPrivateKey)
+	}
+	app := &GitHubApp{
+		AppID: appID,
+		// some CI systems can't store multiline secrets
+		PrivateKey: []byte(strings.ReplaceAll(privateKey, `\n`, "\n")),
+	}
+
+	if installationID := getenv(EnvGitHubAppInstallationID); installationID != "" {
+		if _, err := fmt.Sscan(installationID, &app.InstallationID); err != nil {
+			return nil, fmt.Errorf("invalid $%s: %w", EnvGitHubAppInstallationID, err)
+		}
+	}
+	return app, nil
+}
+
+func (p *AuthProviderWithGitHubApp) GetRepositoryURL(reponame string) string {
+	return fmt.Sprintf("https://%s.git", reponame)
+}
+
+func (p *AuthProviderWithGitHubApp) AuthMethod() (transport.AuthMethod, error) {
+	token, err := p.Token()
+	if err != nil {
+		return nil, err
+	}
+	return &githttp.BasicAuth{
+		Username: githubAppTokenUsername,
+		Password: token,
+	}, nil
+}
+
+// Token returns the cached installation token, or a new one when it is about to expire.
+func (p *AuthProviderWithGitHubApp) Token() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.token != "" && p.clock().Add(githubAppTokenMargin).Before(p.expiresAt) {
+		return p.token, nil
+	}
+
+	key, err := parseRSAPrivateKey(p.app.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("github app %s: %w", p.app.AppID, err)
+	}
+	jwt, err := p.signJWT(key)
+	if err != nil {
+		return "", fmt.Errorf("github app %s: %w", p.app.AppID, err)
+	}
+
+	installationID := p.app.InstallationID
+	if installationID == 0 {
+		installationID, err = p.lookupInstallation(jwt)
+		if err != nil {
+			return "", fmt.Errorf("github app %s: find the installation of %s: %w", p.app.AppID, p.reponame, err)
+		}
+	}
+
+	var created struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	path := fmt.Sprintf("/app/installations/%d/access_tokens", installationID)
+	if err := p.call(http.MethodPost, path, jwt, http.StatusCreated, &created); err != nil {
+		return "", fmt.Errorf("github app %s: create an installation token: %w", p.app.AppID, err)
+	}
+	if created.Token == "" {
+		return "", fmt.Errorf("github app %s: no installation token returned", p.app.AppID)
+	}
+
+	p.token, p.expiresAt = created.Token, created.ExpiresAt
+	return p.token, nil
+}
+
+// lookupInstallation returns the installation of the app in the owner of the repository.
+func (p *AuthProviderWithGitHubApp) lookupInstallation(jwt string) (int64, error) {
+	parts := strings.Split(p.reponame, "/")
+	if len(parts) < 3 {
+		return 0, fmt.Errorf("%s is not a repository", p.reponame)
+	}
+
+	var installation struct {
+		ID int64 `json:"id"`
+	}
+	path := fmt.Sprintf("/repos/%s/%s/installation", parts[1], parts[2])
+	if err := p.call(http.MethodGet, path, jwt, http.StatusOK, &installation); err != nil {
+		return 0, err
+	}
+	return installation.ID, nil
+}
+
+func (p *AuthProviderWithGitHubApp) call(method string, path string, jwt string, expected int, out interface{}) error {
+	apiURL := p.app.APIURL
+	if apiURL == "" {
+		host, _, _ := strings.Cut(p.reponame, "/")
+		apiURL = GitHubAPIURL(host)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(apiURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	client := p.app.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != expected {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, apiErr.Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// signJWT creates the RS256 token authenticating the app itself, valid for the few minutes needed to create an installation token.
+func (p *AuthProviderWithGitHubApp) signJWT(key *rsa.PrivateKey) (string, error) {
+	now := p.clock()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		// backdated against clock drift, as recommended by GitHub
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": p.app.AppID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign jwt: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (p *AuthProviderWithGitHubApp) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// parseRSAPrivateKey reads a PKCS#1 key, as downloaded from GitHub, or a PKCS#8 one.
+func parseRSAPrivateKey(content []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
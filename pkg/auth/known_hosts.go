@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyPolicy decides what happens when a server's host key is not in known_hosts.
+type HostKeyPolicy string
+
+const (
+	// HostKeyStrict refuses servers whose host key is unknown, the default.
+	HostKeyStrict HostKeyPolicy = "strict"
+	// HostKeyAcceptNew records the host key of servers seen for the first time, like ssh's StrictHostKeyChecking=accept-new.
+	// A changed host key is still refused.
+	HostKeyAcceptNew HostKeyPolicy = "accept-new"
+)
+
+// ParseHostKeyPolicy reads the host_key_policy of the configuration files, empty means strict.
+func ParseHostKeyPolicy(s string) (HostKeyPolicy, error) {
+	switch HostKeyPolicy(s) {
+	case "", HostKeyStrict:
+		return HostKeyStrict, nil
+	case HostKeyAcceptNew:
+		return HostKeyAcceptNew, nil
+	default:
+		return "", fmt.Errorf("unknown host_key_policy %q (strict or accept-new)", s)
+	}
+}
+
+// knownHostsMu serializes the updates of known_hosts files by accept-new.
+var knownHostsMu sync.Mutex
+
+// NewHostKeyCallback checks host keys against the knownHosts file with policy.
+func NewHostKeyCallback(knownHosts string, policy HostKeyPolicy) (ssh.HostKeyCallback, error) {
+	if policy == HostKeyAcceptNew {
+		if err := os.MkdirAll(filepath.Dir(knownHosts), 0700); err != nil {
+			return nil, fmt.Errorf("create directory of %s: %w", knownHosts, err)
+		}
+		f, err := os.OpenFile(knownHosts, os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("create %s: %w", knownHosts, err)
+		}
+		f.Close()
+	}
+
+	callback, err := gitssh.NewKnownHostsCallback(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("read known hosts %s: %w", knownHosts, err)
+	}
+	if policy != HostKeyAcceptNew {
+		return callback, nil
+	}
+
+	// the callback doesn't see the lines appended after it was created
+	accepted := make(map[string][]byte)
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			// known, or known with another key which must never be replaced silently
+			return err
+		}
+
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+		normalized := knownhosts.Normalize(hostname)
+		if previous, ok := accepted[normalized]; ok {
+			if bytes.Equal(previous, key.Marshal()) {
+				return nil
+			}
+			return fmt.Errorf("host key of %s changed since it was accepted", hostname)
+		}
+
+		f, err := os.OpenFile(knownHosts, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("record host key of %s: %w", hostname, err)
+		}
+		defer f.Close()
+		line := knownhosts.Line([]string{normalized}, key)
+		if _, err := fmt.Fprintln(f, line); err != nil {
+			return fmt.Errorf("record host key of %s: %w", hostname, err)
+		}
+		accepted[normalized] = key.Marshal()
+		return nil
+	}, nil
+}
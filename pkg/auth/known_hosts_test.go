@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return key
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	knownHosts := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+	key, otherKey := newHostKey(t), newHostKey(t)
+
+	// accept-new creates the file and records unknown hosts
+	callback, err := NewHostKeyCallback(knownHosts, HostKeyAcceptNew)
+	require.NoError(t, err)
+	require.NoError(t, callback("git.example.com:22", remote, key))
+	require.NoError(t, callback("git.example.com:22", remote, key))
+	require.Error(t, callback("git.example.com:22", remote, otherKey))
+
+	content, err := os.ReadFile(knownHosts)
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(content), "git.example.com"))
+	info, err := os.Stat(knownHosts)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// a new callback reads the recorded key, and still refuses a changed one
+	callback, err = NewHostKeyCallback(knownHosts, HostKeyAcceptNew)
+	require.NoError(t, err)
+	require.NoError(t, callback("git.example.com:22", remote, key))
+	require.Error(t, callback("git.example.com:22", remote, otherKey))
+
+	// strict refuses unknown hosts
+	callback, err = NewHostKeyCallback(knownHosts, HostKeyStrict)
+	require.NoError(t, err)
+	require.NoError(t, callback("git.example.com:22", remote, key))
+	require.Error(t, callback("other.example.com:22", remote, key))
+}
+
+func TestParseHostKeyPolicy(t *testing.T) {
+	policy, err := ParseHostKeyPolicy("")
+	require.NoError(t, err)
+	require.Equal(t, HostKeyStrict, policy)
+
+	policy, err = ParseHostKeyPolicy("accept-new")
+	require.NoError(t, err)
+	require.Equal(t, HostKeyAcceptNew, policy)
+
+	_, err = ParseHostKeyPolicy("yes")
+	require.Error(t, err)
+}
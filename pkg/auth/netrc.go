@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// AuthProviderWithNetrc authenticates HTTPS repositories with the login of their host in a .netrc file.
+type AuthProviderWithNetrc struct {
+	netrcFile string
+	reponame  string
+}
+
+// NetrcEntry is a machine, or the default, of a .netrc file.
+type NetrcEntry struct {
+	Machine  string
+	Login    string
+	Password string
+}
+
+// NetrcPath returns $NETRC, or the .netrc (_netrc on Windows) in the home directory.
+func NetrcPath(homeDir string) string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(homeDir, "_netrc")
+	}
+	return filepath.Join(homeDir, ".netrc")
+}
+
+// LookupNetrc returns the entry of host, or the default entry. A missing file has no entries.
+func LookupNetrc(netrcFile string, host string) (*NetrcEntry, error) {
+	content, err := os.ReadFile(netrcFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", netrcFile, err)
+	}
+
+	var fallback *NetrcEntry
+	for _, entry := range parseNetrc(string(content)) {
+		if entry.Machine == host {
+			return entry, nil
+		}
+		if entry.Machine == "" && fallback == nil {
+			fallback = entry
+		}
+	}
+	return fallback, nil
+}
+
+// parseNetrc reads the tokens of a .netrc file. The default entry has an empty Machine.
+func parseNetrc(content string) []*NetrcEntry {
+	entries := make([]*NetrcEntry, 0)
+
+	var current *NetrcEntry
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// a macro definition ends with an empty line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		tokens := strings.Fields(line)
+		for i := 0; i < len(tokens); i++ {
+			next := func() string {
+				if i+1 < len(tokens) {
+					i++
+					return tokens[i]
+				}
+				return ""
+			}
+
+			switch tokens[i] {
+			case "machine":
+				current = &NetrcEntry{Machine: next()}
+				entries = append(entries, current)
+			case "default":
+				current = &NetrcEntry{}
+				entries = append(entries, current)
+			case "login":
+				if current != nil {
+					current.Login = next()
+				}
+			case "password":
+				if current != nil {
+					current.Password = next()
+				}
+			case "account":
+				next()
+			case "macdef":
+				inMacro = true
+				i = len(tokens)
+			}
+		}
+	}
+	return entries
+}
+
+func (p *AuthProviderWithNetrc) GetRepositoryURL(reponame string) string {
+	return fmt.Sprintf("https://%s.git", reponame)
+}
+
+func (p *AuthProviderWithNetrc) AuthMethod() (transport.AuthMethod, error) {
+	entry, err := LookupNetrc(p.netrcFile, RepositoryHost(p.reponame))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil || (entry.Login == "" && entry.Password == "") {
+		return nil, nil
+	}
+	return &http.BasicAuth{
+		Username: entry.Login,
+		Password: entry.Password,
+	}, nil
+}
+
+// RepositoryHost returns the host of a repository name like github.com/org/repo
+func RepositoryHost(reponame string) string {
+	host, _, _ := strings.Cut(reponame, "/")
+	return host
+}
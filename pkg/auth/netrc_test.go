@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/require"
+)
+
+const testNetrc = `# work
+machine github.com
+  login octocat
+  password github-token
+
+macdef init
+machine evil.example.com login macro password macro
+
+machine gitlab.example.com login gitlab-user password gitlab-token account ignored
+default login anonymous password anonymous@example.com
+`
+
+func TestLookupNetrc(t *testing.T) {
+	netrcFile := filepath.Join(t.TempDir(), ".netrc")
+	require.NoError(t, os.WriteFile(netrcFile, []byte(testNetrc), 0600))
+
+	entry, err := LookupNetrc(netrcFile, "github.com")
+	require.NoError(t, err)
+	require.Equal(t, &NetrcEntry{Machine: "github.com", Login: "octocat", Password: "github-token"}, entry)
+
+	entry, err = LookupNetrc(netrcFile, "gitlab.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "gitlab-user", entry.Login)
+	require.Equal(t, "gitlab-token", entry.Password)
+
+	// lines of a macro definition are not entries
+	entry, err = LookupNetrc(netrcFile, "evil.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "anonymous", entry.Login)
+
+	entry, err = LookupNetrc(filepath.Join(t.TempDir(), "missing"), "github.com")
+	require.NoError(t, err)
+	require.Nil(t, entry)
+}
+
+func TestAuthProviderWithNetrc(t *testing.T) {
+	netrcFile := filepath.Join(t.TempDir(), ".netrc")
+	require.NoError(t, os.WriteFile(netrcFile, []byte("machine github.com login octocat password github-token\n"), 0600))
+
+	target := NewAuthProvider(WithNetrc(netrcFile, "github.com/stormcat24/protodep"))
+	require.Equal(t, "https://github.com/stormcat24/protodep.git", target.GetRepositoryURL("github.com/stormcat24/protodep"))
+
+	am, err := target.AuthMethod()
+	require.NoError(t, err)
+	require.Equal(t, &http.BasicAuth{Username: "octocat", Password: "github-token"}, am)
+
+	am, err = NewAuthProvider(WithNetrc(netrcFile, "bitbucket.org/team/api")).AuthMethod()
+	require.NoError(t, err)
+	require.Nil(t, am)
+}
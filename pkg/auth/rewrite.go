@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// URLRewrite replaces the InsteadOf prefix of a repository url by Base, like git's url.<base>.insteadOf.
+type URLRewrite struct {
+	Base      string
+	InsteadOf string
+	// Fallback keeps the original url as second choice when the rewritten one fails.
+	Fallback bool
+}
+
+// AuthLookup returns the AuthProvider of a repository like github.com/org/repo reached over protocol, ssh or https.
+type AuthLookup func(reponame string, protocol string) (AuthProvider, error)
+
+// AuthProviderWithRewrite rewrites the repository urls of another AuthProvider, e.g. to go through a mirror.
+type AuthProviderWithRewrite struct {
+	AuthProvider
+	rewrites []URLRewrite
+	lookup   AuthLookup
+}
+
+// WithURLRewrites wraps provider so that its repository urls are rewritten. The longest matching prefix wins.
+// The credentials of provider are only sent to the original url, those of a rewritten url are found by lookup.
+func WithURLRewrites(provider AuthProvider, rewrites []URLRewrite, lookup AuthLookup) AuthProvider {
+	if len(rewrites) == 0 {
+		return provider
+	}
+	return &AuthProviderWithRewrite{
+		AuthProvider: provider,
+		rewrites:     rewrites,
+		lookup:       lookup,
+	}
+}
+
+func (p *AuthProviderWithRewrite) GetRepositoryURL(reponame string) string {
+	return p.RepositoryURLs(reponame)[0]
+}
+
+// RepositoryURLs returns the rewritten url, followed by the original url if the rewrite allows falling back.
+func (p *AuthProviderWithRewrite) RepositoryURLs(reponame string) []string {
+	original := p.AuthProvider.GetRepositoryURL(reponame)
+
+	var match *URLRewrite
+	for i, rewrite := range p.rewrites {
+		if !strings.HasPrefix(original, rewrite.InsteadOf) {
+			continue
+		}
+		if match == nil || len(rewrite.InsteadOf) > len(match.InsteadOf) {
+			match = &p.rewrites[i]
+		}
+	}
+	if match == nil {
+		return []string{original}
+	}
+
+	rewritten := match.Base + strings.TrimPrefix(original, match.InsteadOf)
+	if match.Fallback && rewritten != original {
+		return []string{rewritten, original}
+	}
+	return []string{rewritten}
+}
+
+// URLAuthMethod returns the credentials to send to url, one of the RepositoryURLs of reponame.
+// A rewritten url gets the credentials of its own host, or none.
+func (p *AuthProviderWithRewrite) URLAuthMethod(reponame string, rawURL string) (transport.AuthMethod, error) {
+	if rawURL == p.AuthProvider.GetRepositoryURL(reponame) {
+		return p.AuthProvider.AuthMethod()
+	}
+	if p.lookup == nil {
+		return nil, nil
+	}
+
+	mirror, protocol, err := URLRepository(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if protocol == "" {
+		return nil, nil
+	}
+	provider, err := p.lookup(mirror, protocol)
+	if err != nil {
+		return nil, fmt.Errorf("credentials of %s: %w", rawURL, err)
+	}
+	if provider == nil {
+		return nil, nil
+	}
+	return provider.AuthMethod()
+}
+
+// URLAuthMethod returns the credentials of provider to send to url, one of the RepositoryURLs of reponame.
+func URLAuthMethod(provider AuthProvider, reponame string, url string) (transport.AuthMethod, error) {
+	if p, ok := provider.(interface {
+		URLAuthMethod(reponame string, url string) (transport.AuthMethod, error)
+	}); ok {
+		return p.URLAuthMethod(reponame, url)
+	}
+	return provider.AuthMethod()
+}
+
+// URLRepository converts an absolute git url to a repository name like github.com/org/repo, and the protocol
+// whose credentials it takes: https, ssh, or empty when it takes none, e.g. for file urls.
+func URLRepository(rawURL string) (string, string, error) {
+	var host, repopath, protocol string
+
+	if strings.Contains(rawURL, "://") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return "", "", fmt.Errorf("parse url %s: %w", rawURL, err)
+		}
+		host, repopath = u.Hostname(), u.Path
+		switch u.Scheme {
+		case "https", "http":
+			protocol = "https"
+		case "ssh", "git+ssh":
+			protocol = "ssh"
+		}
+	} else if hostAndPath := strings.SplitN(rawURL, ":", 2); len(hostAndPath) == 2 {
+		// scp-like syntax: git@github.com:org/repo.git
+		host, repopath, protocol = hostAndPath[0], hostAndPath[1], "ssh"
+		if i := strings.Index(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+	} else {
+		return "", "", fmt.Errorf("unsupported url %s", rawURL)
+	}
+
+	reponame := strings.TrimSuffix(strings.TrimSuffix(host+"/"+strings.TrimPrefix(repopath, "/"), "/"), ".git")
+	return reponame, protocol, nil
+}
+
+// RepositoryURLs returns the urls a repository can be fetched from, in order of preference.
+func RepositoryURLs(provider AuthProvider, reponame string) []string {
+	if p, ok := provider.(interface {
+		RepositoryURLs(reponame string) []string
+	}); ok {
+		return p.RepositoryURLs(reponame)
+	}
+	return []string{provider.GetRepositoryURL(reponame)}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithURLRewrites(t *testing.T) {
+	target := WithURLRewrites(&AuthProviderHTTPS{}, []URLRewrite{
+		{Base: "https://mirror.example.com/github/", InsteadOf: "https://github.com/"},
+		{Base: "https://mirror.example.com/protodep/", InsteadOf: "https://github.com/stormcat24/", Fallback: true},
+	}, nil)
+
+	require.Equal(t, "https://mirror.example.com/github/protocolbuffers/protobuf.git", target.GetRepositoryURL("github.com/protocolbuffers/protobuf"))
+	require.Equal(t, []string{"https://mirror.example.com/github/protocolbuffers/protobuf.git"}, RepositoryURLs(target, "github.com/protocolbuffers/protobuf"))
+
+	// longest prefix wins
+	require.Equal(t, []string{
+		"https://mirror.example.com/protodep/protodep.git",
+		"https://github.com/stormcat24/protodep.git",
+	}, RepositoryURLs(target, "github.com/stormcat24/protodep"))
+
+	// unmatched
+	require.Equal(t, "https://gitlab.com/group/api.git", target.GetRepositoryURL("gitlab.com/group/api"))
+}
+
+func TestWithURLRewritesWithoutRules(t *testing.T) {
+	provider := &AuthProviderWithSSHAgent{}
+	require.Same(t, provider, WithURLRewrites(provider, nil, nil))
+	require.Equal(t, []string{"ssh://github.com/stormcat24/protodep.git"}, RepositoryURLs(provider, "github.com/stormcat24/protodep"))
+}
+
+func TestURLAuthMethod(t *testing.T) {
+	lookups := make([]string, 0)
+	target := WithURLRewrites(NewAuthProvider(WithHTTPS("octocat", "github-token")), []URLRewrite{
+		{Base: "https://mirror.example.com/github/", InsteadOf: "https://github.com/", Fallback: true},
+		{Base: "ssh://git@mirror.example.com/protodep/", InsteadOf: "https://github.com/stormcat24/"},
+	}, func(reponame string, protocol string) (AuthProvider, error) {
+		lookups = append(lookups, protocol+" "+reponame)
+		if reponame == "mirror.example.com/protodep/protodep" {
+			return NewAuthProvider(WithHTTPS("mirror-user", "mirror-token")), nil
+		}
+		return NewAuthProvider(WithHTTPS("", "")), nil
+	})
+
+	// the mirror never receives the credentials of the original host
+	urls := RepositoryURLs(target, "github.com/protocolbuffers/protobuf")
+	am, err := URLAuthMethod(target, "github.com/protocolbuffers/protobuf", urls[0])
+	require.NoError(t, err)
+	require.Nil(t, am)
+
+	// the original url keeps them
+	am, err = URLAuthMethod(target, "github.com/protocolbuffers/protobuf", urls[1])
+	require.NoError(t, err)
+	require.Equal(t, "octocat", am.(*http.BasicAuth).Username)
+
+	am, err = URLAuthMethod(target, "github.com/stormcat24/protodep", target.GetRepositoryURL("github.com/stormcat24/protodep"))
+	require.NoError(t, err)
+	require.Equal(t, "mirror-user", am.(*http.BasicAuth).Username)
+
+	require.Equal(t, []string{"https mirror.example.com/github/protocolbuffers/protobuf", "ssh mirror.example.com/protodep/protodep"}, lookups)
+}
+
+func TestURLRepository(t *testing.T) {
+	cases := map[string][2]string{
+		"https://mirror.example.com:8443/github/org/api.git": {"mirror.example.com/github/org/api", "https"},
+		"ssh://git@github.com/org/shared.git":                {"github.com/org/shared", "ssh"},
+		"git@bitbucket.org:team/defs.git":                    {"bitbucket.org/team/defs", "ssh"},
+		"file:///srv/git/org/api":                            {"/srv/git/org/api", ""},
+	}
+	for url, expected := range cases {
+		reponame, protocol, err := URLRepository(url)
+		require.NoError(t, err, url)
+		require.Equal(t, expected, [2]string{reponame, protocol}, url)
+	}
+
+	_, _, err := URLRepository("local-dir")
+	require.Error(t, err)
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// githubTokenPattern matches personal access tokens (classic and fine-grained), OAuth, GitHub App and legacy tokens.
+var githubTokenPattern = regexp.MustCompile(`^(gh[pousr]_[a-zA-Z0-9]{36}|github_pat_[a-zA-Z0-9]{22}_[a-zA-Z0-9]{59}|v[0-9]\.[0-9a-f]{40}|[0-9a-f]{40})$`)
+
+// ValidateTokenFormat checks the format of the tokens of hosts which have a known one, only github.com for now.
+func ValidateTokenFormat(host string, token string) error {
+	if strings.TrimSpace(token) == "" {
+		return errors.New("token must not be empty")
+	}
+	if host == "github.com" && !githubTokenPattern.MatchString(token) {
+		return errors.New("not a GitHub token, expected ghp_..., github_pat_... or another GitHub token format")
+	}
+	return nil
+}
+
+// Redact hides a secret for display, keeping a prefix long enough to recognize the kind of token.
+func Redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) < 16 {
+		return "********"
+	}
+	return secret[:4] + "********"
+}
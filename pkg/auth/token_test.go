@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTokenFormat(t *testing.T) {
+	require.NoError(t, ValidateTokenFormat("github.com", "ghp_"+strings.Repeat("a", 36)))
+	require.NoError(t, ValidateTokenFormat("github.com", "ghs_"+strings.Repeat("B", 36)))
+	require.NoError(t, ValidateTokenFormat("github.com", "github_pat_"+strings.Repeat("a", 22)+"_"+strings.Repeat("b", 59)))
+	require.Error(t, ValidateTokenFormat("github.com", "ghp_short"))
+	require.Error(t, ValidateTokenFormat("github.com", "my-password"))
+	require.Error(t, ValidateTokenFormat("gitlab.example.com", " "))
+	require.NoError(t, ValidateTokenFormat("gitlab.example.com", "glpat-anything"))
+}
+
+func TestRedact(t *testing.T) {
+	require.Equal(t, "", Redact(""))
+	require.Equal(t, "********", Redact("short"))
+	require.Equal(t, "ghp_********", Redact("ghp_"+strings.Repeat("a", 36)))
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// TransportOptions configures the HTTP transport git uses for http(s) repositories.
+type TransportOptions struct {
+	// CABundle is a PEM file with certificates trusted in addition to the system ones.
+	CABundle string
+	// InsecureSkipTLS disables the verification of server certificates.
+	InsecureSkipTLS bool
+}
+
+// NewHTTPClient builds a client honoring HTTPS_PROXY, HTTP_PROXY and NO_PROXY and the given TLS settings.
+func NewHTTPClient(opts TransportOptions) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: opts.InsecureSkipTLS,
+	}
+
+	if opts.CABundle != "" {
+		pem, err := os.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("CA bundle contains no PEM certificates: " + opts.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: transport,
+	}, nil
+}
+
+// InstallHTTPTransport makes git use a client built from opts for every http and https repository.
+func InstallHTTPTransport(opts TransportOptions) error {
+	c, err := NewHTTPClient(opts)
+	if err != nil {
+		return err
+	}
+
+	t := githttp.NewClient(c)
+	client.InstallProtocol("https", t)
+	client.InstallProtocol("http", t)
+	return nil
+}
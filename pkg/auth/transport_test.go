@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caBundle, cert, 0644))
+
+	untrusted, err := NewHTTPClient(TransportOptions{})
+	require.NoError(t, err)
+	_, err = untrusted.Get(server.URL)
+	require.Error(t, err)
+
+	trusted, err := NewHTTPClient(TransportOptions{CABundle: caBundle})
+	require.NoError(t, err)
+	res, err := trusted.Get(server.URL)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	insecure, err := NewHTTPClient(TransportOptions{InsecureSkipTLS: true})
+	require.NoError(t, err)
+	res, err = insecure.Get(server.URL)
+	require.NoError(t, err)
+	res.Body.Close()
+}
+
+func TestNewHTTPClientInvalidBundle(t *testing.T) {
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caBundle, []byte("not a certificate"), 0644))
+
+	_, err := NewHTTPClient(TransportOptions{CABundle: caBundle})
+	require.Error(t, err)
+
+	_, err = NewHTTPClient(TransportOptions{CABundle: filepath.Join(t.TempDir(), "missing.pem")})
+	require.Error(t, err)
+}
@@ -0,0 +1,317 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// EnvCacheDir overrides the cache location when the --cache-dir flag is not given.
+const EnvCacheDir = "PROTODEP_CACHE_DIR"
+
+const cacheDirName = "protodep"
+
+// legacyDirName is where older versions cloned the repositories, {home}/.protodep next to the login session.
+const legacyDirName = ".protodep"
+
+// Dir resolves the directory where dependency repositories are cached.
+// The precedence is: override (--cache-dir), $PROTODEP_CACHE_DIR, {home}/.protodep when older versions cached
+// repositories there, $XDG_CACHE_HOME/protodep, {home}/.cache/protodep.
+func Dir(homeDir string, override string) string {
+	if override != "" {
+		return override
+	}
+	if dir := os.Getenv(EnvCacheDir); dir != "" {
+		return dir
+	}
+	legacy := filepath.Join(homeDir, legacyDirName)
+	if repos, _ := filepath.Glob(filepath.Join(legacy, "*", "*", "*", ".git")); len(repos) > 0 {
+		return legacy
+	}
+	if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" {
+		return filepath.Join(xdg, cacheDirName)
+	}
+	return filepath.Join(homeDir, ".cache", cacheDirName)
+}
+
+type Cache interface {
+	Dir() string
+	List() ([]Repository, error)
+	Prune(olderThan time.Duration) ([]Repository, error)
+	Remove(name string) error
+	Verify() ([]VerifyResult, error)
+	Clean() error
+	Touch(name string) error
+}
+
+// Repository is a single cloned dependency repository inside the cache.
+type Repository struct {
+	// Name is the repository name relative to the cache, e.g. github.com/stormcat24/protodep
+	Name     string
+	Path     string
+	Size     int64
+	LastUsed time.Time
+}
+
+type VerifyResult struct {
+	Repository Repository
+	Objects    int
+	Err        error
+}
+
+type cache struct {
+	dir string
+}
+
+func New(dir string) Cache {
+	return &cache{
+		dir: dir,
+	}
+}
+
+func (c *cache) Dir() string {
+	return c.dir
+}
+
+func (c *cache) List() ([]Repository, error) {
+	repos := make([]Repository, 0)
+
+	if _, err := os.Stat(c.dir); os.IsNotExist(err) {
+		return repos, nil
+	}
+
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || path == c.dir {
+			return nil
+		}
+		if !isRepository(path) {
+			return nil
+		}
+
+		repo, err := c.stat(path)
+		if err != nil {
+			return err
+		}
+		repos = append(repos, *repo)
+
+		// Nested directories belong to the repository worktree.
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list cache %s: %w", c.dir, err)
+	}
+
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].Name < repos[j].Name
+	})
+	return repos, nil
+}
+
+func (c *cache) Prune(olderThan time.Duration) ([]Repository, error) {
+	repos, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := time.Now().Add(-olderThan)
+	pruned := make([]Repository, 0)
+	for _, repo := range repos {
+		if repo.LastUsed.After(threshold) {
+			continue
+		}
+		if err := c.Remove(repo.Name); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, repo)
+	}
+	return pruned, nil
+}
+
+func (c *cache) Remove(name string) error {
+	path, err := c.repositoryPath(name)
+	if err != nil {
+		return err
+	}
+	if !isRepository(path) {
+		return fmt.Errorf("%s is not cached in %s", name, c.dir)
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("remove %s: %w", path, err)
+	}
+
+	// Drop the host/organization directories left empty behind the repository.
+	for dir := filepath.Dir(path); dir != c.dir && strings.HasPrefix(dir, c.dir); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *cache) Verify() ([]VerifyResult, error) {
+	repos, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]VerifyResult, 0, len(repos))
+	for _, repo := range repos {
+		objects, err := verifyRepository(repo.Path)
+		results = append(results, VerifyResult{
+			Repository: repo,
+			Objects:    objects,
+			Err:        err,
+		})
+	}
+	return results, nil
+}
+
+// Clean removes every cached repository but keeps the cache directory itself.
+// Only directories laid out like host/owner/repo with a git repository inside are removed, so a cache directory
+// shared with other data keeps it.
+func (c *cache) Clean() error {
+	repos, err := c.List()
+	if err != nil {
+		return err
+	}
+	for _, repo := range repos {
+		if len(strings.Split(repo.Name, "/")) < 3 {
+			continue
+		}
+		if err := c.Remove(repo.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Touch records that the repository has just been used.
+func (c *cache) Touch(name string) error {
+	path, err := c.repositoryPath(name)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
+
+func (c *cache) repositoryPath(name string) (string, error) {
+	path := filepath.Join(c.dir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(c.dir, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("invalid repository name %q", name)
+	}
+	return path, nil
+}
+
+func (c *cache) stat(path string) (*Repository, error) {
+	rel, err := filepath.Rel(c.dir, path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	err = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{
+		Name:     filepath.ToSlash(rel),
+		Path:     path,
+		Size:     size,
+		LastUsed: info.ModTime(),
+	}, nil
+}
+
+func isRepository(path string) bool {
+	stat, err := os.Stat(filepath.Join(path, git.GitDirName))
+	return err == nil && stat.IsDir()
+}
+
+// verifyRepository re-hashes every stored object and checks that all references point to existing objects.
+func verifyRepository(path string) (int, error) {
+	rep, err := git.PlainOpen(path)
+	if err != nil {
+		return 0, fmt.Errorf("open repository: %w", err)
+	}
+
+	iter, err := rep.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return 0, fmt.Errorf("iterate objects: %w", err)
+	}
+
+	objects := 0
+	err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+		r, err := obj.Reader()
+		if err != nil {
+			return fmt.Errorf("read object %s: %w", obj.Hash(), err)
+		}
+		defer r.Close()
+
+		hasher := plumbing.NewHasher(obj.Type(), obj.Size())
+		if _, err := io.Copy(hasher, r); err != nil {
+			return fmt.Errorf("read object %s: %w", obj.Hash(), err)
+		}
+		if sum := hasher.Sum(); sum != obj.Hash() {
+			return fmt.Errorf("object %s is corrupted (content hashes to %s)", obj.Hash(), sum)
+		}
+		objects++
+		return nil
+	})
+	if err != nil {
+		return objects, err
+	}
+
+	refs, err := rep.References()
+	if err != nil {
+		return objects, fmt.Errorf("iterate references: %w", err)
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		if _, err := rep.Storer.EncodedObject(plumbing.AnyObject, ref.Hash()); err != nil {
+			if errors.Is(err, plumbing.ErrObjectNotFound) {
+				return fmt.Errorf("reference %s points to missing object %s", ref.Name(), ref.Hash())
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return objects, err
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDir(t *testing.T) {
+	t.Setenv(EnvCacheDir, "")
+	t.Setenv("XDG_CACHE_HOME", "")
+	require.Equal(t, filepath.Join("/home/protodep", ".cache", "protodep"), Dir("/home/protodep", ""))
+
+	t.Setenv("XDG_CACHE_HOME", "/xdg")
+	require.Equal(t, filepath.Join("/xdg", "protodep"), Dir("/home/protodep", ""))
+
+	t.Setenv(EnvCacheDir, "/env")
+	require.Equal(t, "/env", Dir("/home/protodep", ""))
+
+	require.Equal(t, "/flag", Dir("/home/protodep", "/flag"))
+
+	// the cache of older versions is kept in use
+	t.Setenv(EnvCacheDir, "")
+	home := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".protodep"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".protodep", "config"), []byte("[hosts]\n"), 0600))
+	require.Equal(t, filepath.Join("/xdg", "protodep"), Dir(home, ""))
+	initRepository(t, filepath.Join(home, ".protodep", "github.com", "stormcat24", "protodep"))
+	require.Equal(t, filepath.Join(home, ".protodep"), Dir(home, ""))
+}
+
+func TestListPruneRemove(t *testing.T) {
+	dir := t.TempDir()
+	initRepository(t, filepath.Join(dir, "github.com", "stormcat24", "protodep"))
+	initRepository(t, filepath.Join(dir, "gitlab.com", "group", "subgroup", "api"))
+
+	target := New(dir)
+
+	repos, err := target.List()
+	require.NoError(t, err)
+	require.Len(t, repos, 2)
+	require.Equal(t, "github.com/stormcat24/protodep", repos[0].Name)
+	require.Equal(t, "gitlab.com/group/subgroup/api", repos[1].Name)
+	require.True(t, repos[0].Size > 0)
+
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(repos[1].Path, old, old))
+	require.NoError(t, target.Touch("github.com/stormcat24/protodep"))
+
+	pruned, err := target.Prune(24 * time.Hour)
+	require.NoError(t, err)
+	require.Len(t, pruned, 1)
+	require.Equal(t, "gitlab.com/group/subgroup/api", pruned[0].Name)
+	require.NoDirExists(t, filepath.Join(dir, "gitlab.com"))
+
+	require.Error(t, target.Remove("github.com/stormcat24"))
+	require.Error(t, target.Remove("../outside"))
+	require.NoError(t, target.Remove("github.com/stormcat24/protodep"))
+
+	repos, err = target.List()
+	require.NoError(t, err)
+	require.Empty(t, repos)
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	initRepository(t, filepath.Join(dir, "github.com", "stormcat24", "protodep"))
+
+	results, err := New(dir).Verify()
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, 3, results[0].Objects)
+}
+
+func initRepository(t *testing.T, path string) {
+	rep, err := git.PlainInit(path, false)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(path, "api.proto"), []byte("syntax = \"proto3\";\n"), 0644))
+
+	wt, err := rep.Worktree()
+	require.NoError(t, err)
+	_, err = wt.Add("api.proto")
+	require.NoError(t, err)
+	_, err = wt.Commit("initial", &git.CommitOptions{
+		Author: &object.Signature{Name: "protodep", Email: "protodep@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+}
+
+func TestCleanKeepsOtherData(t *testing.T) {
+	dir := t.TempDir()
+	initRepository(t, filepath.Join(dir, "github.com", "stormcat24", "protodep"))
+	initRepository(t, filepath.Join(dir, "tool", "checkout"))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "other", "data"), 0755))
+
+	require.NoError(t, New(dir).Clean())
+	require.NoDirExists(t, filepath.Join(dir, "github.com"))
+	require.DirExists(t, filepath.Join(dir, "tool", "checkout", ".git"))
+	require.DirExists(t, filepath.Join(dir, "other", "data"))
+
+	require.NoError(t, New(filepath.Join(dir, "missing")).Clean())
+}
@@ -0,0 +1,299 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode"
+)
+
+// PatchableOptions are the file options patch_options may rewrite.
+var PatchableOptions = []string{
+	"go_package",
+	"java_package",
+	"java_outer_classname",
+	"csharp_namespace",
+	"php_namespace",
+	"php_metadata_namespace",
+	"php_class_prefix",
+	"ruby_package",
+	"objc_class_prefix",
+	"swift_prefix",
+}
+
+// UnpatchedPackages are the packages smart-patch never moves, with their sub-packages: the well-known types are
+// referenced by their upstream names everywhere.
+var UnpatchedPackages = []string{
+	"google.protobuf",
+}
+
+// IsUnpatchedPackage reports whether smart-patch leaves the files of pkg as they are upstream.
+func IsUnpatchedPackage(pkg string) bool {
+	for _, unpatched := range UnpatchedPackages {
+		if pkg == unpatched || strings.HasPrefix(pkg, unpatched+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Annotation is an option smart-patch sets on vendored declarations, with their original full name.
+type Annotation struct {
+	// Option is the name of the extension, e.g. api.derived_from
+	Option string
+	// Extendee is the options message the extension extends, e.g. google.protobuf.MessageOptions
+	Extendee string
+	// Kind is what is annotated, e.g. messages
+	Kind string
+	// Key is the protodep.toml key configuring the annotation.
+	Key string
+}
+
+// PatchAnnotations returns the annotations of smart-patch, the one of messages then the ones of enums and services
+// when configured.
+func (d *ProtoDep) PatchAnnotations() []Annotation {
+	annotations := make([]Annotation, 0, 3)
+	if d.PatchAnnotation != "" {
+		annotations = append(annotations, Annotation{Option: d.PatchAnnotation, Extendee: "google.protobuf.MessageOptions", Kind: "messages", Key: "patch_package_with_message_annotation"})
+	}
+	if d.PatchEnumAnnotation != "" {
+		annotations = append(annotations, Annotation{Option: d.PatchEnumAnnotation, Extendee: "google.protobuf.EnumOptions", Kind: "enums", Key: "patch_enum_annotation"})
+	}
+	if d.PatchServiceAnnotation != "" {
+		annotations = append(annotations, Annotation{Option: d.PatchServiceAnnotation, Extendee: "google.protobuf.ServiceOptions", Kind: "services", Key: "patch_service_annotation"})
+	}
+	return annotations
+}
+
+// validateAnnotations checks each annotation has its own option name.
+func validateAnnotations(annotations []Annotation) error {
+	keys := make(map[string]string, len(annotations))
+	for _, annotation := range annotations {
+		name := strings.TrimPrefix(annotation.Option, ".")
+		if key, ok := keys[name]; ok {
+			return fmt.Errorf("'%s' and '%s' both use %s, each annotation needs its own option", key, annotation.Key, name)
+		}
+		keys[name] = annotation.Key
+	}
+	return nil
+}
+
+// PatchPackageData is the data of patch_package templates, for each vendored file.
+type PatchPackageData struct {
+	// Path is the default package, the directory of the vendored file with / turned into ., e.g. proto.vendor.acme
+	Path string
+	// OriginalPackage is the package declared upstream, e.g. acme.billing.v1
+	OriginalPackage string
+	// Dir is the directory of the vendored file, e.g. proto/vendor/acme
+	Dir string
+	// File is the name of the vendored file without extension, e.g. billing
+	File string
+	// DependencyPath is the path of the dependency in proto_outdir, e.g. vendor/acme
+	DependencyPath string
+}
+
+var packageComponent = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParsePatchPackage parses a patch_package template.
+func ParsePatchPackage(text string) (*template.Template, error) {
+	tmpl, err := template.New("patch_package").Option("missingkey=error").Funcs(patchOptionFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("patch_package: %w", err)
+	}
+	return tmpl, nil
+}
+
+// ExecutePatchPackage renders the package of a vendored file, sanitized into a valid proto package.
+func ExecutePatchPackage(tmpl *template.Template, data PatchPackageData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("patch_package: %w", err)
+	}
+	pkg := SanitizePackage(b.String())
+	if err := ValidatePackage(pkg); err != nil {
+		return "", fmt.Errorf("patch_package %q: %w", tmpl.Root.String(), err)
+	}
+	return pkg, nil
+}
+
+// SanitizePackage turns s into a proto package: characters invalid in identifiers become _, components starting with
+// a digit get a _ prefix and empty components are dropped. e.g. proto/my-api.2023 becomes proto.my_api._2023
+func SanitizePackage(s string) string {
+	components := strings.FieldsFunc(strings.TrimSpace(s), func(r rune) bool { return r == '.' || r == '/' })
+	for i, component := range components {
+		component = strings.Map(func(r rune) rune {
+			if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+				return r
+			}
+			return '_'
+		}, component)
+		if component[0] >= '0' && component[0] <= '9' {
+			component = "_" + component
+		}
+		components[i] = component
+	}
+	return strings.Join(components, ".")
+}
+
+// ValidatePackage checks pkg is a valid proto package, dot separated identifiers.
+func ValidatePackage(pkg string) error {
+	if pkg == "" {
+		return errors.New("package is empty")
+	}
+	for _, component := range strings.Split(pkg, ".") {
+		if !packageComponent.MatchString(component) {
+			return fmt.Errorf("%q is not a valid package", pkg)
+		}
+	}
+	return nil
+}
+
+// PatchOptionData is the data of patch_options templates, for each vendored file.
+type PatchOptionData struct {
+	// Package is the proto package given by smart-patch, e.g. proto.vendor.acme
+	Package string
+	// OriginalPackage is the package declared upstream, e.g. acme.billing.v1
+	OriginalPackage string
+	// Dir is the directory of the vendored file, e.g. proto/vendor/acme
+	Dir string
+	// File is the name of the vendored file without extension, e.g. billing
+	File string
+	// Value is the upstream value of the option, empty when the file doesn't set it.
+	Value string
+	// GoModule is the module path of the go.mod next to protodep.toml, which must exist when a template uses it.
+	GoModule string
+}
+
+var patchOptionFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"base":    func(s string) string { return path.Base(strings.ReplaceAll(s, ".", "/")) },
+	"pascal":  pascalCase,
+	"goname":  goPackageName,
+}
+
+// ParsePatchOption parses the template of a patch_options entry.
+func ParsePatchOption(name string, text string) (*template.Template, error) {
+	known := false
+	for _, option := range PatchableOptions {
+		known = known || option == name
+	}
+	if !known {
+		return nil, fmt.Errorf("patch_options can't rewrite %q, only %s", name, strings.Join(PatchableOptions, ", "))
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(patchOptionFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("patch_options %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// UsesField reports whether the template reads the field of its data, e.g. {{.GoModule}}
+func UsesField(tmpl *template.Template, field string) bool {
+	var uses func(node parse.Node) bool
+	uses = func(node parse.Node) bool {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return false
+			}
+			for _, child := range n.Nodes {
+				if uses(child) {
+					return true
+				}
+			}
+		case *parse.ActionNode:
+			return uses(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return false
+			}
+			for _, cmd := range n.Cmds {
+				if uses(cmd) {
+					return true
+				}
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				if uses(arg) {
+					return true
+				}
+			}
+		case *parse.FieldNode:
+			return len(n.Ident) > 0 && n.Ident[0] == field
+		case *parse.ChainNode:
+			return uses(n.Node)
+		case *parse.IfNode:
+			return uses(n.Pipe) || uses(n.List) || uses(n.ElseList)
+		case *parse.RangeNode:
+			return uses(n.Pipe) || uses(n.List) || uses(n.ElseList)
+		case *parse.WithNode:
+			return uses(n.Pipe) || uses(n.List) || uses(n.ElseList)
+		case *parse.TemplateNode:
+			return uses(n.Pipe)
+		}
+		return false
+	}
+	return tmpl.Tree != nil && uses(tmpl.Tree.Root)
+}
+
+// ExecutePatchOptions renders patch_options for a file, by option name. An empty value removes the option.
+func ExecutePatchOptions(templates map[string]*template.Template, data PatchOptionData, values map[string]string) (map[string]string, error) {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rendered := make(map[string]string, len(templates))
+	for _, name := range names {
+		var b strings.Builder
+		data.Value = values[name]
+		if err := templates[name].Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("patch_options %s: %w", name, err)
+		}
+		rendered[name] = strings.TrimSpace(b.String())
+	}
+	return rendered, nil
+}
+
+// pascalCase capitalizes each word of a dotted or slashed name, e.g. acme.billing_v1 becomes Acme.BillingV1
+func pascalCase(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		switch {
+		case r == '.' || r == '/' || r == '\\':
+			b.WriteRune(r)
+			upper = true
+		case r == '_' || r == '-':
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// goPackageName turns the last element of a path into a Go package name, e.g. proto/billing-v1 becomes billingv1
+func goPackageName(s string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, path.Base(s))
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "_" + name
+	}
+	return name
+}
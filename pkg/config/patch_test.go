@@ -0,0 +1,118 @@
+package config
+
+import (
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutePatchOptions(t *testing.T) {
+	templates := make(map[string]*template.Template)
+	for name, text := range map[string]string{
+		"go_package":       "{{.GoModule}}/{{.Dir}};{{goname .Dir}}",
+		"csharp_namespace": "{{pascal .Package}}",
+		"php_namespace":    `{{pascal .Package | replace "." "\\"}}`,
+		"ruby_package":     `{{replace "Upstream" "Acme" .Value}}`,
+		"swift_prefix":     "",
+	} {
+		tmpl, err := ParsePatchOption(name, text)
+		require.NoError(t, err)
+		templates[name] = tmpl
+	}
+
+	rendered, err := ExecutePatchOptions(templates, PatchOptionData{
+		Package:  "proto.vendor.billing_v1",
+		Dir:      "proto/vendor/billing-v1",
+		GoModule: "github.com/acme/svc",
+	}, map[string]string{"ruby_package": "Upstream::Billing"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"go_package":       "github.com/acme/svc/proto/vendor/billing-v1;billingv1",
+		"csharp_namespace": "Proto.Vendor.BillingV1",
+		"php_namespace":    `Proto\Vendor\BillingV1`,
+		"ruby_package":     "Acme::Billing",
+		"swift_prefix":     "",
+	}, rendered)
+}
+
+func TestParsePatchOption(t *testing.T) {
+	_, err := ParsePatchOption("go_pkg", "x")
+	require.ErrorContains(t, err, "can't rewrite")
+	_, err = ParsePatchOption("go_package", "{{.Dir")
+	require.Error(t, err)
+	_, err = ParsePatchOption("go_package", "{{unknown .Dir}}")
+	require.Error(t, err)
+}
+
+func TestUsesField(t *testing.T) {
+	for text, expected := range map[string]bool{
+		"{{.GoModule}}/{{.Dir}}":                                    true,
+		"{{if .Value}}{{.Value}}{{else}}{{lower .GoModule}}{{end}}": true,
+		"{{with .Dir}}{{.}}{{end}}":                                 false,
+		"GoModule/{{.Dir}}":                                         false,
+	} {
+		tmpl, err := ParsePatchOption("go_package", text)
+		require.NoError(t, err)
+		require.Equal(t, expected, UsesField(tmpl, "GoModule"), text)
+	}
+}
+
+func TestValidatePatchOptions(t *testing.T) {
+	conf := ProtoDep{ProtoOutdir: "proto", PatchOptions: map[string]string{"go_package": "x"}}
+	require.Error(t, conf.Validate())
+	conf.PatchAnnotation = "api.derived_from"
+	require.NoError(t, conf.Validate())
+}
+
+func TestSanitizePackage(t *testing.T) {
+	for input, expected := range map[string]string{
+		"proto.upstream.foo":       "proto.upstream.foo",
+		"proto/my-api/2023":        "proto.my_api._2023",
+		" acme..vendor. ":          "acme.vendor",
+		"acme.grpc-gateway.v1beta": "acme.grpc_gateway.v1beta",
+	} {
+		require.Equal(t, expected, SanitizePackage(input), input)
+	}
+
+	require.NoError(t, ValidatePackage("acme.vendor_1"))
+	require.Error(t, ValidatePackage(""))
+	require.Error(t, ValidatePackage("acme.1vendor"))
+	require.Error(t, ValidatePackage("acme..vendor"))
+}
+
+func TestExecutePatchPackage(t *testing.T) {
+	data := PatchPackageData{
+		Path:            "proto.vendor.grpc_gateway",
+		OriginalPackage: "grpc.gateway.examples",
+		Dir:             "proto/vendor/grpc-gateway",
+		DependencyPath:  "vendor/grpc-gateway",
+	}
+	for text, expected := range map[string]string{
+		"acme.{{.Path}}":                     "acme.proto.vendor.grpc_gateway",
+		"acme.vendor.{{.OriginalPackage}}":   "acme.vendor.grpc.gateway.examples",
+		"acme/{{.DependencyPath}}":           "acme.vendor.grpc_gateway",
+		"{{base .Dir}}.{{.OriginalPackage}}": "grpc_gateway.grpc.gateway.examples",
+	} {
+		tmpl, err := ParsePatchPackage(text)
+		require.NoError(t, err)
+		pkg, err := ExecutePatchPackage(tmpl, data)
+		require.NoError(t, err, text)
+		require.Equal(t, expected, pkg, text)
+	}
+
+	tmpl, err := ParsePatchPackage("{{.File}}")
+	require.NoError(t, err)
+	_, err = ExecutePatchPackage(tmpl, data)
+	require.ErrorContains(t, err, "package is empty")
+
+	_, err = ParsePatchPackage("{{.Path")
+	require.Error(t, err)
+}
+
+func TestIsUnpatchedPackage(t *testing.T) {
+	require.True(t, IsUnpatchedPackage("google.protobuf"))
+	require.True(t, IsUnpatchedPackage("google.protobuf.compiler"))
+	require.False(t, IsUnpatchedPackage("google.protobufx"))
+	require.False(t, IsUnpatchedPackage("google.api"))
+}
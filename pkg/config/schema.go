@@ -2,31 +2,150 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"strings"
+
+	"github.com/stormcat24/protodep/pkg/auth"
 )
 
 type ProtoDep struct {
-	ProtoOutdir     string               `toml:"proto_outdir"`
-	PatchAnnotation string               `toml:"patch_package_with_message_annotation"`
-	Dependencies    []ProtoDepDependency `toml:"dependencies"`
+	ProtoOutdir            string               `toml:"proto_outdir"`
+	PatchAnnotation        string               `toml:"patch_package_with_message_annotation"`
+	PatchEnumAnnotation    string               `toml:"patch_enum_annotation,omitempty"`
+	PatchServiceAnnotation string               `toml:"patch_service_annotation,omitempty"`
+	PatchAnnotationField   int                  `toml:"patch_annotation_field,omitempty"`
+	PatchPackage           string               `toml:"patch_package,omitempty"`
+	PatchOptions           map[string]string    `toml:"patch_options,omitempty"`
+	PatchMapping           string               `toml:"patch_mapping,omitempty"`
+	SignatureKeyring       string               `toml:"signature_keyring,omitempty"`
+	URLRewrites            []URLRewrite         `toml:"url_rewrites,omitempty"`
+	Dependencies           []ProtoDepDependency `toml:"dependencies"`
+}
+
+// URLRewrite replaces any of the InsteadOf prefixes of a repository url by Base, like git's url.<base>.insteadOf.
+// With Fallback, the original url is tried when the rewritten one fails.
+type URLRewrite struct {
+	Base      string   `toml:"base"`
+	InsteadOf []string `toml:"instead_of"`
+	Fallback  bool     `toml:"fallback,omitempty"`
 }
 
 func (d *ProtoDep) Validate() error {
 	if strings.TrimSpace(d.ProtoOutdir) == "" {
 		return errors.New("required 'proto_outdir'")
 	}
+	for _, rewrite := range d.URLRewrites {
+		if err := rewrite.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(d.PatchOptions) > 0 && d.PatchAnnotation == "" {
+		return errors.New("'patch_options' requires 'patch_package_with_message_annotation'")
+	}
+	if d.PatchEnumAnnotation != "" && d.PatchAnnotation == "" {
+		return errors.New("'patch_enum_annotation' requires 'patch_package_with_message_annotation'")
+	}
+	if d.PatchServiceAnnotation != "" && d.PatchAnnotation == "" {
+		return errors.New("'patch_service_annotation' requires 'patch_package_with_message_annotation'")
+	}
+	if err := validateAnnotations(d.PatchAnnotations()); err != nil {
+		return err
+	}
+	if d.PatchAnnotationField != 0 {
+		if d.PatchAnnotation == "" {
+			return errors.New("'patch_annotation_field' requires 'patch_package_with_message_annotation'")
+		}
+		for _, annotation := range d.PatchAnnotations() {
+			if err := ValidatePackage(strings.TrimPrefix(annotation.Option, ".")); err != nil {
+				return fmt.Errorf("%s: %w", annotation.Key, err)
+			}
+		}
+		if err := ValidateFieldNumber(d.PatchAnnotationField); err != nil {
+			return fmt.Errorf("patch_annotation_field: %w", err)
+		}
+	}
+	if d.PatchMapping != "" && d.PatchAnnotation == "" {
+		return errors.New("'patch_mapping' requires 'patch_package_with_message_annotation'")
+	}
+	if d.PatchPackage != "" && d.PatchAnnotation == "" {
+		return errors.New("'patch_package' requires 'patch_package_with_message_annotation'")
+	}
+	if _, err := ParsePatchPackage(d.PatchPackage); err != nil {
+		return err
+	}
+	for name, text := range d.PatchOptions {
+		if _, err := ParsePatchOption(name, text); err != nil {
+			return err
+		}
+	}
+	for _, dep := range d.Dependencies {
+		if dep.RequireSignature && dep.SignatureKeyring == "" && d.SignatureKeyring == "" {
+			return fmt.Errorf("%s requires a signature, but no 'signature_keyring' is configured", dep.Target)
+		}
+		if _, err := auth.ParseHostKeyPolicy(dep.HostKeyPolicy); err != nil {
+			return fmt.Errorf("%s: %w", dep.Target, err)
+		}
+		if dep.PatchPackage != "" && d.PatchAnnotation == "" {
+			return fmt.Errorf("%s: 'patch_package' requires 'patch_package_with_message_annotation'", dep.Target)
+		}
+		if dep.Patch != nil && *dep.Patch && d.PatchAnnotation == "" {
+			return fmt.Errorf("%s: 'patch' requires 'patch_package_with_message_annotation'", dep.Target)
+		}
+		if dep.PatchPackage != "" && !dep.PatchEnabled() {
+			return fmt.Errorf("%s: 'patch_package' has no effect with 'patch = false'", dep.Target)
+		}
+		if _, err := ParsePatchPackage(dep.PatchPackage); err != nil {
+			return fmt.Errorf("%s: %w", dep.Target, err)
+		}
+	}
+	return nil
+}
+
+// ValidateFieldNumber checks number is a valid proto field number, outside of the range reserved by protobuf.
+func ValidateFieldNumber(number int) error {
+	if number < 1 || number > 536870911 {
+		return fmt.Errorf("field number %d is out of range (1 to 536870911)", number)
+	}
+	if number >= 19000 && number <= 19999 {
+		return fmt.Errorf("field number %d is reserved by protobuf (19000 to 19999)", number)
+	}
+	return nil
+}
+
+func (r *URLRewrite) Validate() error {
+	if strings.TrimSpace(r.Base) == "" {
+		return errors.New("required 'base' in url_rewrites")
+	}
+	if len(r.InsteadOf) == 0 {
+		return fmt.Errorf("required 'instead_of' in url_rewrites of %s", r.Base)
+	}
 	return nil
 }
 
 type ProtoDepDependency struct {
-	Target   string   `toml:"target"`
-	Subgroup string   `toml:"subgroup"`
-	Revision string   `toml:"revision"`
-	Branch   string   `toml:"branch"`
-	Path     string   `toml:"path"`
-	Ignores  []string `toml:"ignores"`
-	Includes []string `toml:"includes"`
-	Protocol string   `toml:"protocol"`
+	Target           string   `toml:"target"`
+	Subgroup         string   `toml:"subgroup"`
+	Revision         string   `toml:"revision"`
+	Branch           string   `toml:"branch"`
+	Path             string   `toml:"path"`
+	Ignores          []string `toml:"ignores"`
+	Includes         []string `toml:"includes"`
+	Protocol         string   `toml:"protocol"`
+	Submodules       bool     `toml:"submodules,omitempty"`
+	RequireSignature bool     `toml:"require_signature,omitempty"`
+	SignatureKeyring string   `toml:"signature_keyring,omitempty"`
+	Signer           string   `toml:"signer,omitempty"`
+	SignedTag        string   `toml:"signed_tag,omitempty"`
+	IdentityFile     string   `toml:"identity_file,omitempty"`
+	KnownHosts       string   `toml:"known_hosts,omitempty"`
+	HostKeyPolicy    string   `toml:"host_key_policy,omitempty"`
+	PatchPackage     string   `toml:"patch_package,omitempty"`
+	Patch            *bool    `toml:"patch,omitempty"`
+}
+
+// PatchEnabled reports whether smart-patch applies to the dependency, unless it sets patch = false.
+func (d *ProtoDepDependency) PatchEnabled() bool {
+	return d.Patch == nil || *d.Patch
 }
 
 func (d *ProtoDepDependency) Repository() string {
@@ -3,6 +3,7 @@ package config
 import (
 	"testing"
 
+	"github.com/BurntSushi/toml"
 	"github.com/stretchr/testify/require"
 )
 
@@ -32,3 +33,74 @@ func TestDirectory(t *testing.T) {
 
 	require.Equal(t, "./examples", protruded.Directory())
 }
+
+func TestValidatePatchAnnotationField(t *testing.T) {
+	conf := ProtoDep{ProtoOutdir: "proto", PatchAnnotationField: 50000}
+	require.ErrorContains(t, conf.Validate(), "requires 'patch_package_with_message_annotation'")
+
+	conf.PatchAnnotation = "api.derived_from"
+	require.NoError(t, conf.Validate())
+	conf.PatchAnnotation = ".api.derived_from"
+	require.NoError(t, conf.Validate())
+
+	conf.PatchAnnotation = "api.derived-from"
+	require.Error(t, conf.Validate())
+
+	conf.PatchAnnotation = "api.derived_from"
+	for _, number := range []int{0, 1, 18999, 20000, 536870911} {
+		conf.PatchAnnotationField = number
+		require.NoError(t, conf.Validate(), number)
+	}
+	for _, number := range []int{-1, 19000, 19999, 536870912} {
+		conf.PatchAnnotationField = number
+		require.Error(t, conf.Validate(), number)
+	}
+}
+
+func TestValidatePatchEnumAndServiceAnnotations(t *testing.T) {
+	conf := ProtoDep{ProtoOutdir: "proto", PatchEnumAnnotation: "api.enum_of"}
+	require.ErrorContains(t, conf.Validate(), "'patch_enum_annotation' requires")
+	conf = ProtoDep{ProtoOutdir: "proto", PatchServiceAnnotation: "api.service_of"}
+	require.ErrorContains(t, conf.Validate(), "'patch_service_annotation' requires")
+
+	conf.PatchAnnotation = "api.derived_from"
+	conf.PatchEnumAnnotation = "api.enum_of"
+	require.NoError(t, conf.Validate())
+	require.Len(t, conf.PatchAnnotations(), 3)
+	require.Equal(t, "google.protobuf.ServiceOptions", conf.PatchAnnotations()[2].Extendee)
+
+	conf.PatchEnumAnnotation = ".api.derived_from"
+	require.ErrorContains(t, conf.Validate(), "each annotation needs its own option")
+}
+
+func TestValidatePatchMapping(t *testing.T) {
+	conf := ProtoDep{ProtoOutdir: "proto", PatchMapping: "proto/mapping.json"}
+	require.ErrorContains(t, conf.Validate(), "'patch_mapping' requires")
+	conf.PatchAnnotation = "api.derived_from"
+	require.NoError(t, conf.Validate())
+}
+
+func TestPatchEnabled(t *testing.T) {
+	var conf ProtoDep
+	_, err := toml.Decode(`proto_outdir = "proto"
+patch_package_with_message_annotation = "api.derived_from"
+
+[[dependencies]]
+  target = "github.com/protocolbuffers/protobuf/src"
+  patch = false
+
+[[dependencies]]
+  target = "github.com/acme/api"
+`, &conf)
+	require.NoError(t, err)
+	require.NoError(t, conf.Validate())
+	require.False(t, conf.Dependencies[0].PatchEnabled())
+	require.True(t, conf.Dependencies[1].PatchEnabled())
+
+	conf.Dependencies[0].PatchPackage = "acme.{{.Path}}"
+	require.ErrorContains(t, conf.Validate(), "no effect with 'patch = false'")
+
+	enabled := true
+	conf = ProtoDep{ProtoOutdir: "proto", Dependencies: []ProtoDepDependency{{Target: "github.com/acme/api", Patch: &enabled}}}
+	require.ErrorContains(t, conf.Validate(), "'patch' requires")
+}
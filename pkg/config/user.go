@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/stormcat24/protodep/pkg/auth"
+)
+
+// UserConfig holds the per-user settings shared by all projects.
+type UserConfig struct {
+	CABundle    string                `toml:"ca_bundle"`
+	URLRewrites []URLRewrite          `toml:"url_rewrites"`
+	Credentials map[string]Credential `toml:"credentials"`
+}
+
+// Credential authenticates the repositories of a host, or of a host and path prefix like "github.com/my-org".
+type Credential struct {
+	Username         string `toml:"username"`
+	Password         string `toml:"password"`
+	IdentityFile     string `toml:"identity_file"`
+	IdentityPassword string `toml:"identity_password"`
+	KnownHosts       string `toml:"known_hosts"`
+	HostKeyPolicy    string `toml:"host_key_policy"`
+
+	// GitHub App used instead of username and password, see auth.GitHubApp.
+	AppID          string `toml:"app_id"`
+	InstallationID int64  `toml:"installation_id"`
+	PrivateKeyFile string `toml:"private_key_file"`
+	APIURL         string `toml:"api_url"`
+}
+
+// LookupCredential returns the credential whose key is the longest prefix of reponame, on path boundaries.
+func LookupCredential(credentials map[string]Credential, reponame string) (string, Credential, bool) {
+	var matched string
+	for key := range credentials {
+		prefix := strings.TrimSuffix(key, "/")
+		if reponame != prefix && !strings.HasPrefix(reponame, prefix+"/") {
+			continue
+		}
+		if len(prefix) > len(strings.TrimSuffix(matched, "/")) {
+			matched = key
+		}
+	}
+	if matched == "" {
+		return "", Credential{}, false
+	}
+	return matched, credentials[matched], true
+}
+
+func (c *UserConfig) Validate() error {
+	for _, rewrite := range c.URLRewrites {
+		if err := rewrite.Validate(); err != nil {
+			return err
+		}
+	}
+	for key, cred := range c.Credentials {
+		if _, err := auth.ParseHostKeyPolicy(cred.HostKeyPolicy); err != nil {
+			return fmt.Errorf("credentials of %s: %w", key, err)
+		}
+		if cred.AppID != "" && cred.PrivateKeyFile == "" {
+			return fmt.Errorf("credentials of %s: app_id requires private_key_file", key)
+		}
+	}
+	return nil
+}
+
+// UserConfigPath returns $XDG_CONFIG_HOME/protodep/config.toml, or {home}/.config/protodep/config.toml
+func UserConfigPath(homeDir string) string {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(configHome, "protodep", "config.toml")
+}
+
+// LoadUserConfig reads the user configuration file. A missing file is an empty configuration.
+func LoadUserConfig(path string) (*UserConfig, error) {
+	var conf UserConfig
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &conf, nil
+		}
+		return nil, fmt.Errorf("load %s: %w", path, err)
+	}
+
+	if _, err := toml.Decode(string(content), &conf); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("found invalid configuration in %s: %w", path, err)
+	}
+	conf.resolvePaths(filepath.Dir(path))
+
+	return &conf, nil
+}
+
+// resolvePaths makes the relative paths of the configuration relative to dir, the directory of the file, rather than
+// to the working directory or ~/.ssh. Absolute paths and paths relative to the home directory with ~/ are kept.
+func (c *UserConfig) resolvePaths(dir string) {
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) || path == "~" || strings.HasPrefix(path, "~/") {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+
+	c.CABundle = resolve(c.CABundle)
+	for key, cred := range c.Credentials {
+		cred.IdentityFile = resolve(cred.IdentityFile)
+		cred.KnownHosts = resolve(cred.KnownHosts)
+		cred.PrivateKeyFile = resolve(cred.PrivateKeyFile)
+		c.Credentials[key] = cred
+	}
+}
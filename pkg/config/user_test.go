@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserConfigPath(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	require.Equal(t, filepath.Join("/home/protodep", ".config", "protodep", "config.toml"), UserConfigPath("/home/protodep"))
+
+	t.Setenv("XDG_CONFIG_HOME", "/xdg")
+	require.Equal(t, filepath.Join("/xdg", "protodep", "config.toml"), UserConfigPath("/home/protodep"))
+}
+
+func TestLoadUserConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	missing, err := LoadUserConfig(filepath.Join(dir, "missing.toml"))
+	require.NoError(t, err)
+	require.Empty(t, missing.URLRewrites)
+
+	path := filepath.Join(dir, "config.toml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+[[url_rewrites]]
+  base = "https://mirror.example.com/github/"
+  instead_of = ["https://github.com/", "ssh://github.com/"]
+  fallback = true
+`), 0644))
+
+	actual, err := LoadUserConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, []URLRewrite{{
+		Base:      "https://mirror.example.com/github/",
+		InsteadOf: []string{"https://github.com/", "ssh://github.com/"},
+		Fallback:  true,
+	}}, actual.URLRewrites)
+
+	// relative paths are relative to the configuration file
+	require.NoError(t, os.WriteFile(path, []byte(`
+ca_bundle = "ca.pem"
+
+[credentials."gitlab.example.com"]
+  identity_file = "keys/id_gitlab"
+  known_hosts = "~/.ssh/known_hosts_gitlab"
+
+[credentials."github.com/org"]
+  app_id = "42"
+  private_key_file = "/etc/protodep/app.pem"
+`), 0644))
+	actual, err = LoadUserConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "ca.pem"), actual.CABundle)
+	require.Equal(t, filepath.Join(dir, "keys", "id_gitlab"), actual.Credentials["gitlab.example.com"].IdentityFile)
+	require.Equal(t, "~/.ssh/known_hosts_gitlab", actual.Credentials["gitlab.example.com"].KnownHosts)
+	require.Equal(t, "/etc/protodep/app.pem", actual.Credentials["github.com/org"].PrivateKeyFile)
+
+	require.NoError(t, os.WriteFile(path, []byte("[[url_rewrites]]\n  base = \"https://mirror.example.com/\"\n"), 0644))
+	_, err = LoadUserConfig(path)
+	require.Error(t, err)
+}
+
+func TestLookupCredential(t *testing.T) {
+	credentials := map[string]Credential{
+		"github.com":         {Username: "default"},
+		"github.com/my-org/": {Username: "org"},
+		"gitlab.example.com": {Username: "gitlab"},
+	}
+
+	key, cred, ok := LookupCredential(credentials, "github.com/my-org/api")
+	require.True(t, ok)
+	require.Equal(t, "github.com/my-org/", key)
+	require.Equal(t, "org", cred.Username)
+
+	_, cred, ok = LookupCredential(credentials, "github.com/my-org-fork/api")
+	require.True(t, ok)
+	require.Equal(t, "default", cred.Username)
+
+	_, cred, ok = LookupCredential(credentials, "gitlab.example.com/group/subgroup/api")
+	require.True(t, ok)
+	require.Equal(t, "gitlab", cred.Username)
+
+	_, _, ok = LookupCredential(credentials, "bitbucket.org/team/api")
+	require.False(t, ok)
+}
@@ -0,0 +1,245 @@
+package protoparse
+
+// Node is a declaration of a proto file.
+type Node interface {
+	// Pos is the span of the whole declaration, from its first keyword to its closing ; or }.
+	Pos() Span
+}
+
+// File is a parsed proto file. Spans are byte offsets of Content, so that edits keep comments and formatting.
+type File struct {
+	Content []byte
+	// Syntax is proto2 or proto3, empty for editions files.
+	Syntax string
+	// Edition is the edition of editions files, e.g. 2023.
+	Edition  string
+	Package  *Package
+	Imports  []*Import
+	Options  []*Option
+	Messages []*Message
+	Enums    []*Enum
+	Services []*Service
+	Extends  []*Extend
+	// Decls are the top level declarations in source order.
+	Decls    []Node
+	Comments []Token
+}
+
+type Package struct {
+	Name     string
+	NameSpan Span
+	Span     Span
+}
+
+type Import struct {
+	// Modifier is public, weak, option (edition 2024) or empty.
+	Modifier string
+	Path     string
+	PathSpan Span
+	Span     Span
+}
+
+// Option is an option statement, or one option of a compact list like [deprecated = true].
+type Option struct {
+	// Name is the option name without whitespace, e.g. java_package or (my.ext).field
+	Name     string
+	NameSpan Span
+	// Value is the decoded value of strings, the source text of other constants.
+	Value     string
+	IsString  bool
+	ValueSpan Span
+	// Refs are the extensions named in the option name, e.g. (my.ext), and in the brackets of a message literal value,
+	// e.g. [my.ext] or the type of [type.googleapis.com/my.Message].
+	Refs []TypeRef
+	Span Span
+}
+
+// TypeRef is a reference to a message or enum type, e.g. google.protobuf.Timestamp or .pkg.Message
+type TypeRef struct {
+	Name string
+	Span Span
+}
+
+// Block holds the positions of the braces of a declaration body.
+type Block struct {
+	Open  int
+	Close int
+}
+
+type Message struct {
+	// Visibility is export, local (edition 2024) or empty.
+	Visibility string
+	Name       string
+	NameSpan   Span
+	// Group is set for proto2 groups, the message declared by a group field.
+	Group    bool
+	Options  []*Option
+	Fields   []*Field
+	Messages []*Message
+	Enums    []*Enum
+	Oneofs   []*Oneof
+	Extends  []*Extend
+	Decls    []Node
+	Body     Block
+	Span     Span
+}
+
+type Field struct {
+	// Label is optional, required, repeated or empty.
+	Label string
+	// Type is the value type of maps, and group for groups.
+	Type TypeRef
+	// KeyType is the key type of maps, nil otherwise.
+	KeyType  *TypeRef
+	Name     string
+	NameSpan Span
+	Number   string
+	Options  []*Option
+	// Group is the message of a group field.
+	Group *Message
+	Span  Span
+}
+
+type Oneof struct {
+	Name     string
+	NameSpan Span
+	Options  []*Option
+	Fields   []*Field
+	Decls    []Node
+	Body     Block
+	Span     Span
+}
+
+type Enum struct {
+	// Visibility is export, local (edition 2024) or empty.
+	Visibility string
+	Name       string
+	NameSpan   Span
+	Options    []*Option
+	Values     []*EnumValue
+	Decls      []Node
+	Body       Block
+	Span       Span
+}
+
+type EnumValue struct {
+	Name     string
+	NameSpan Span
+	Number   string
+	Options  []*Option
+	Span     Span
+}
+
+type Service struct {
+	Name     string
+	NameSpan Span
+	Options  []*Option
+	RPCs     []*RPC
+	Decls    []Node
+	Body     Block
+	Span     Span
+}
+
+type RPC struct {
+	Name         string
+	NameSpan     Span
+	Input        TypeRef
+	InputStream  bool
+	Output       TypeRef
+	OutputStream bool
+	Options      []*Option
+	// Body is nil for methods declared without braces.
+	Body *Block
+	Span Span
+}
+
+type Extend struct {
+	Type   TypeRef
+	Fields []*Field
+	Decls  []Node
+	Body   Block
+	Span   Span
+}
+
+// Statement is a declaration without content of interest, like syntax, reserved or extensions.
+type Statement struct {
+	Keyword string
+	Span    Span
+}
+
+func (n *Package) Pos() Span   { return n.Span }
+func (n *Import) Pos() Span    { return n.Span }
+func (n *Option) Pos() Span    { return n.Span }
+func (n *Message) Pos() Span   { return n.Span }
+func (n *Field) Pos() Span     { return n.Span }
+func (n *Oneof) Pos() Span     { return n.Span }
+func (n *Enum) Pos() Span      { return n.Span }
+func (n *EnumValue) Pos() Span { return n.Span }
+func (n *Service) Pos() Span   { return n.Span }
+func (n *RPC) Pos() Span       { return n.Span }
+func (n *Extend) Pos() Span    { return n.Span }
+func (n *Statement) Pos() Span { return n.Span }
+
+// Walk calls fn for each declaration of the file, depth first in source order, with the declarations enclosing it.
+// Children are skipped when fn returns false.
+func (f *File) Walk(fn func(node Node, parents []Node) bool) {
+	walkDecls(f.Decls, nil, fn)
+}
+
+func walkDecls(decls []Node, parents []Node, fn func(node Node, parents []Node) bool) {
+	for _, node := range decls {
+		if !fn(node, parents) {
+			continue
+		}
+		var children []Node
+		switch n := node.(type) {
+		case *Message:
+			children = n.Decls
+		case *Field:
+			if n.Group != nil {
+				children = []Node{n.Group}
+			}
+		case *Oneof:
+			children = n.Decls
+		case *Enum:
+			children = n.Decls
+		case *Service:
+			children = n.Decls
+		case *RPC:
+			children = make([]Node, 0, len(n.Options))
+			for _, opt := range n.Options {
+				children = append(children, opt)
+			}
+		case *Extend:
+			children = n.Decls
+		}
+		if len(children) > 0 {
+			walkDecls(children, append(parents[:len(parents):len(parents)], node), fn)
+		}
+	}
+}
+
+// Scope returns the name prefix of the declarations inside parents, e.g. Outer.Inner for nested messages.
+// Oneofs, fields and extends don't define a scope.
+func Scope(parents []Node) string {
+	scope := ""
+	for _, parent := range parents {
+		name := ""
+		switch p := parent.(type) {
+		case *Message:
+			name = p.Name
+		case *Enum:
+			name = p.Name
+		case *Service:
+			name = p.Name
+		}
+		if name == "" {
+			continue
+		}
+		if scope != "" {
+			scope += "."
+		}
+		scope += name
+	}
+	return scope
+}
@@ -0,0 +1,112 @@
+package protoparse
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Edit replaces the bytes of Span with Text. An empty span inserts Text.
+type Edit struct {
+	Span Span
+	Text string
+}
+
+// Apply returns content with edits applied, the bytes outside of the edited spans are kept untouched.
+// Insertions at the same offset are applied in the order given. Overlapping edits are an error.
+func Apply(content []byte, edits []Edit) ([]byte, error) {
+	sorted := make([]Edit, len(edits))
+	copy(sorted, edits)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Span.Start != sorted[j].Span.Start {
+			return sorted[i].Span.Start < sorted[j].Span.Start
+		}
+		// insertions first, then the replacement starting there
+		return sorted[i].Span.End < sorted[j].Span.End
+	})
+
+	out := make([]byte, 0, len(content))
+	last := 0
+	for _, edit := range sorted {
+		if edit.Span.Start < last || edit.Span.End < edit.Span.Start || edit.Span.End > len(content) {
+			return nil, fmt.Errorf("edit of bytes %d-%d overlaps another edit or is out of range", edit.Span.Start, edit.Span.End)
+		}
+		out = append(out, content[last:edit.Span.Start]...)
+		out = append(out, edit.Text...)
+		last = edit.Span.End
+	}
+	return append(out, content[last:]...), nil
+}
+
+// LineSpan extends span to its whole lines, trailing line break included, when nothing else is on them.
+// Removing the returned span doesn't leave a blank line behind.
+func LineSpan(content []byte, span Span) Span {
+	start := span.Start
+	for start > 0 && (content[start-1] == ' ' || content[start-1] == '\t') {
+		start--
+	}
+	if start > 0 && content[start-1] != '\n' {
+		return span
+	}
+
+	end := span.End
+	for end < len(content) && (content[end] == ' ' || content[end] == '\t' || content[end] == '\r') {
+		end++
+	}
+	if end < len(content) && content[end] != '\n' {
+		return span
+	}
+	if end < len(content) {
+		end++
+	}
+	return Span{start, end}
+}
+
+// LineBreak returns the line break used by content, \r\n or \n.
+func LineBreak(content []byte) string {
+	if bytes.Contains(content, []byte("\r\n")) {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// Indentation returns the whitespace before offset on its line, and false when the line has other content before it.
+func Indentation(content []byte, offset int) (string, bool) {
+	start := offset
+	for start > 0 && (content[start-1] == ' ' || content[start-1] == '\t') {
+		start--
+	}
+	if start > 0 && content[start-1] != '\n' {
+		return "", false
+	}
+	return string(content[start:offset]), true
+}
+
+// LineEnd returns the end of the line of offset, before its line break and after a trailing line comment,
+// and false when code follows on the same line.
+func (f *File) LineEnd(offset int) (int, bool) {
+	for i := offset; i < len(f.Content); i++ {
+		switch f.Content[i] {
+		case ' ', '\t':
+			continue
+		case '\r':
+			if i+1 < len(f.Content) && f.Content[i+1] == '\n' {
+				return i, true
+			}
+			continue
+		case '\n':
+			return i, true
+		}
+		for _, comment := range f.Comments {
+			if comment.Span.Start == i && comment.Text[1] == '/' {
+				end := comment.Span.End
+				if f.Content[end-1] == '\r' {
+					end--
+				}
+				return end, true
+			}
+		}
+		return offset, false
+	}
+	return len(f.Content), true
+}
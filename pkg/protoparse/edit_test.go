@@ -0,0 +1,60 @@
+package protoparse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApply(t *testing.T) {
+	content := []byte("package a.b;\n")
+	out, err := Apply(content, []Edit{
+		{Span: Span{8, 11}, Text: "c.d"},
+		{Span: Span{0, 0}, Text: "// one\n"},
+		{Span: Span{0, 0}, Text: "// two\n"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "// one\n// two\npackage c.d;\n", string(out))
+
+	_, err = Apply(content, []Edit{{Span: Span{0, 9}}, {Span: Span{8, 11}}})
+	require.Error(t, err)
+	_, err = Apply(content, []Edit{{Span: Span{8, 20}}})
+	require.Error(t, err)
+}
+
+func TestLineSpan(t *testing.T) {
+	content := []byte("a {\n\toption x = 1;  \r\n  b = 2; option y = 3;\n}")
+	require.Equal(t, "\toption x = 1;  \r\n", string(content[LineSpan(content, Span{5, 18}).Start:LineSpan(content, Span{5, 18}).End]))
+	require.Equal(t, Span{31, 43}, LineSpan(content, Span{31, 43}))
+}
+
+func TestIndentation(t *testing.T) {
+	content := []byte("a {\n\t  b;\n}")
+	indent, ok := Indentation(content, 7)
+	require.True(t, ok)
+	require.Equal(t, "\t  ", indent)
+	_, ok = Indentation(content, 2)
+	require.False(t, ok)
+}
+
+func TestUnquote(t *testing.T) {
+	for literal, expected := range map[string]string{
+		`"a\"b"`:         `a"b`,
+		`'it\'s'`:        `it's`,
+		`"\x41\101\n"`:   "AA\n",
+		`"é"`:            "é",
+		`"{braces}"`:     "{braces}",
+		`"back\\slash"`:  `back\slash`,
+		`"tab\tnewline"`: "tab\tnewline",
+	} {
+		value, err := Unquote(literal)
+		require.NoError(t, err, literal)
+		require.Equal(t, expected, value, literal)
+	}
+	_, err := Unquote(`"\q"`)
+	require.Error(t, err)
+
+	value, err := Unquote(Quote("a \"quoted\"\n\\ value"))
+	require.NoError(t, err)
+	require.Equal(t, "a \"quoted\"\n\\ value", value)
+}
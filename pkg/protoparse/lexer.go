@@ -0,0 +1,264 @@
+package protoparse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenKind is the lexical class of a token.
+type TokenKind int
+
+const (
+	TokenIdent TokenKind = iota
+	TokenInt
+	TokenFloat
+	TokenString
+	// TokenSymbol is a single punctuation character, like { ; = or .
+	TokenSymbol
+	TokenComment
+)
+
+// Span is a range of bytes of the source, End excluded.
+type Span struct {
+	Start int
+	End   int
+}
+
+// Token is a lexical token and its position in the source.
+type Token struct {
+	Kind TokenKind
+	// Text is the token as written, quotes and comment markers included.
+	Text string
+	Span Span
+}
+
+// Error is a syntax error at a position of the source.
+type Error struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+func newError(content []byte, offset int, format string, args ...interface{}) *Error {
+	line, column := Position(content, offset)
+	return &Error{Line: line, Column: column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Position returns the line and column, starting at 1, of a byte offset of content.
+func Position(content []byte, offset int) (int, int) {
+	if offset > len(content) {
+		offset = len(content)
+	}
+	line, column := 1, 1
+	for _, b := range content[:offset] {
+		if b == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
+
+// Tokenize splits a proto source into tokens, comments included and whitespace excluded.
+func Tokenize(content []byte) ([]Token, error) {
+	tokens := make([]Token, 0, len(content)/4)
+	for i := 0; i < len(content); {
+		c := content[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == '\v':
+			i++
+			continue
+		case c == '/' && i+1 < len(content) && content[i+1] == '/':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			tokens = append(tokens, Token{Kind: TokenComment, Text: string(content[start:i]), Span: Span{start, i}})
+			continue
+		case c == '/' && i+1 < len(content) && content[i+1] == '*':
+			end := strings.Index(string(content[i+2:]), "*/")
+			if end < 0 {
+				return nil, newError(content, start, "unterminated comment")
+			}
+			i += 2 + end + 2
+			tokens = append(tokens, Token{Kind: TokenComment, Text: string(content[start:i]), Span: Span{start, i}})
+			continue
+		case c == '"' || c == '\'':
+			i++
+			for ; i < len(content) && content[i] != c; i++ {
+				if content[i] == '\\' {
+					i++
+				} else if content[i] == '\n' {
+					break
+				}
+			}
+			if i >= len(content) || content[i] != c {
+				return nil, newError(content, start, "unterminated string")
+			}
+			i++
+			tokens = append(tokens, Token{Kind: TokenString, Text: string(content[start:i]), Span: Span{start, i}})
+			continue
+		case isLetter(c):
+			for i < len(content) && (isLetter(content[i]) || isDigit(content[i])) {
+				i++
+			}
+			tokens = append(tokens, Token{Kind: TokenIdent, Text: string(content[start:i]), Span: Span{start, i}})
+			continue
+		case isDigit(c) || (c == '.' && i+1 < len(content) && isDigit(content[i+1])):
+			kind := TokenInt
+			hex := c == '0' && i+1 < len(content) && (content[i+1] == 'x' || content[i+1] == 'X')
+			for i < len(content) {
+				d := content[i]
+				if d == '.' {
+					kind = TokenFloat
+				} else if !hex && (d == 'e' || d == 'E') {
+					kind = TokenFloat
+					if i+1 < len(content) && (content[i+1] == '+' || content[i+1] == '-') {
+						i++
+					}
+				} else if !isLetter(d) && !isDigit(d) {
+					break
+				}
+				i++
+			}
+			tokens = append(tokens, Token{Kind: kind, Text: string(content[start:i]), Span: Span{start, i}})
+			continue
+		default:
+			i++
+			tokens = append(tokens, Token{Kind: TokenSymbol, Text: string(c), Span: Span{start, i}})
+		}
+	}
+	return tokens, nil
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Unquote decodes a string literal, single or double quoted, with the escapes of the protobuf language.
+func Unquote(literal string) (string, error) {
+	if len(literal) < 2 || literal[0] != literal[len(literal)-1] || (literal[0] != '"' && literal[0] != '\'') {
+		return "", fmt.Errorf("invalid string literal %s", literal)
+	}
+	s := literal[1 : len(literal)-1]
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return "", fmt.Errorf("invalid escape in %s", literal)
+		}
+		switch c := s[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			b.WriteByte(c)
+		case 'x', 'X':
+			n, value := 0, 0
+			for ; n < 2 && i+1 < len(s) && isHexDigit(s[i+1]); n++ {
+				i++
+				value = value*16 + hexValue(s[i])
+			}
+			if n == 0 {
+				return "", fmt.Errorf("invalid hex escape in %s", literal)
+			}
+			b.WriteByte(byte(value))
+		case 'u', 'U':
+			size := 4
+			if c == 'U' {
+				size = 8
+			}
+			if i+size >= len(s) {
+				return "", fmt.Errorf("invalid unicode escape in %s", literal)
+			}
+			value := 0
+			for n := 0; n < size; n++ {
+				i++
+				if !isHexDigit(s[i]) {
+					return "", fmt.Errorf("invalid unicode escape in %s", literal)
+				}
+				value = value*16 + hexValue(s[i])
+			}
+			b.WriteRune(rune(value))
+		default:
+			if c < '0' || c > '7' {
+				return "", fmt.Errorf("invalid escape \\%c in %s", c, literal)
+			}
+			value := int(c - '0')
+			for n := 1; n < 3 && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '7'; n++ {
+				i++
+				value = value*8 + int(s[i]-'0')
+			}
+			b.WriteByte(byte(value))
+		}
+	}
+	return b.String(), nil
+}
+
+// Quote writes s as a double quoted string literal.
+func Quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) int {
+	switch {
+	case isDigit(c):
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	default:
+		return int(c-'A') + 10
+	}
+}
@@ -0,0 +1,891 @@
+package protoparse
+
+import (
+	"strings"
+)
+
+type parser struct {
+	content []byte
+	tokens  []Token
+	pos     int
+}
+
+// Parse parses a proto2, proto3 or editions file. Comments are kept in File.Comments.
+func Parse(content []byte) (*File, error) {
+	all, err := Tokenize(content)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{Content: content}
+	tokens := make([]Token, 0, len(all))
+	for _, tok := range all {
+		if tok.Kind == TokenComment {
+			file.Comments = append(file.Comments, tok)
+		} else {
+			tokens = append(tokens, tok)
+		}
+	}
+
+	p := &parser{content: content, tokens: tokens}
+	if err := p.parseFile(file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.tokens)
+}
+
+// peek returns the token n positions ahead, an empty symbol at the end of the file.
+func (p *parser) peek(n int) Token {
+	if p.pos+n >= len(p.tokens) {
+		end := len(p.content)
+		return Token{Kind: TokenSymbol, Span: Span{end, end}}
+	}
+	return p.tokens[p.pos+n]
+}
+
+func (p *parser) next() Token {
+	tok := p.peek(0)
+	if !p.eof() {
+		p.pos++
+	}
+	return tok
+}
+
+// is reports whether the next token is the keyword or symbol text.
+func (p *parser) is(n int, text string) bool {
+	tok := p.peek(n)
+	return (tok.Kind == TokenIdent || tok.Kind == TokenSymbol) && tok.Text == text
+}
+
+func (p *parser) accept(text string) (Token, bool) {
+	if p.is(0, text) {
+		return p.next(), true
+	}
+	return Token{}, false
+}
+
+func (p *parser) expect(text string) (Token, error) {
+	if tok, ok := p.accept(text); ok {
+		return tok, nil
+	}
+	return Token{}, p.unexpected("expected %q", text)
+}
+
+func (p *parser) ident() (Token, error) {
+	if p.peek(0).Kind != TokenIdent {
+		return Token{}, p.unexpected("expected an identifier")
+	}
+	return p.next(), nil
+}
+
+func (p *parser) unexpected(format string, args ...interface{}) error {
+	tok := p.peek(0)
+	found := "end of file"
+	if !p.eof() {
+		found = tok.Text
+	}
+	return newError(p.content, tok.Span.Start, format+", found %q", append(args, found)...)
+}
+
+// fullIdent reads a dotted name like google.protobuf.Timestamp, or .pkg.Message when leadingDot.
+func (p *parser) fullIdent(leadingDot bool) (string, Span, error) {
+	start := p.peek(0).Span.Start
+	var b strings.Builder
+	if leadingDot {
+		if _, ok := p.accept("."); ok {
+			b.WriteByte('.')
+		}
+	}
+	tok, err := p.ident()
+	if err != nil {
+		return "", Span{}, err
+	}
+	b.WriteString(tok.Text)
+	end := tok.Span.End
+	for p.is(0, ".") && p.peek(1).Kind == TokenIdent {
+		p.next()
+		tok := p.next()
+		b.WriteByte('.')
+		b.WriteString(tok.Text)
+		end = tok.Span.End
+	}
+	return b.String(), Span{start, end}, nil
+}
+
+func (p *parser) typeRef() (TypeRef, error) {
+	name, span, err := p.fullIdent(true)
+	return TypeRef{Name: name, Span: span}, err
+}
+
+// stringLiteral reads adjacent string literals, which are concatenated.
+func (p *parser) stringLiteral() (string, Span, error) {
+	if p.peek(0).Kind != TokenString {
+		return "", Span{}, p.unexpected("expected a string")
+	}
+	start := p.peek(0).Span.Start
+	var b strings.Builder
+	end := start
+	for p.peek(0).Kind == TokenString {
+		tok := p.next()
+		value, err := Unquote(tok.Text)
+		if err != nil {
+			return "", Span{}, newError(p.content, tok.Span.Start, "%v", err)
+		}
+		b.WriteString(value)
+		end = tok.Span.End
+	}
+	return b.String(), Span{start, end}, nil
+}
+
+// skipStatement skips the tokens up to the ; ending the current statement, for reserved and extensions.
+func (p *parser) skipStatement(keyword Token) (*Statement, error) {
+	depth := 0
+	for !p.eof() {
+		tok := p.next()
+		if tok.Kind != TokenSymbol {
+			continue
+		}
+		switch tok.Text {
+		case "{", "[", "(", "<":
+			depth++
+		case "}", "]", ")", ">":
+			depth--
+		case ";":
+			if depth == 0 {
+				return &Statement{Keyword: keyword.Text, Span: Span{keyword.Span.Start, tok.Span.End}}, nil
+			}
+		}
+	}
+	return nil, p.unexpected("expected \";\" to end %s", keyword.Text)
+}
+
+func (p *parser) parseFile(file *File) error {
+	for !p.eof() {
+		if tok, ok := p.accept(";"); ok {
+			file.Decls = append(file.Decls, &Statement{Keyword: ";", Span: tok.Span})
+			continue
+		}
+
+		tok := p.peek(0)
+		if tok.Kind != TokenIdent {
+			return p.unexpected("expected a declaration")
+		}
+		keyword := tok.Text
+		if p.isVisibility("message") || p.isVisibility("enum") {
+			keyword = p.peek(1).Text
+		}
+		switch keyword {
+		case "syntax", "edition":
+			p.next()
+			if _, err := p.expect("="); err != nil {
+				return err
+			}
+			value, _, err := p.stringLiteral()
+			if err != nil {
+				return err
+			}
+			end, err := p.expect(";")
+			if err != nil {
+				return err
+			}
+			if tok.Text == "syntax" {
+				file.Syntax = value
+			} else {
+				file.Edition = value
+			}
+			file.Decls = append(file.Decls, &Statement{Keyword: tok.Text, Span: Span{tok.Span.Start, end.Span.End}})
+		case "package":
+			p.next()
+			name, span, err := p.fullIdent(false)
+			if err != nil {
+				return err
+			}
+			end, err := p.expect(";")
+			if err != nil {
+				return err
+			}
+			file.Package = &Package{Name: name, NameSpan: span, Span: Span{tok.Span.Start, end.Span.End}}
+			file.Decls = append(file.Decls, file.Package)
+		case "import":
+			p.next()
+			imp := &Import{}
+			if (p.is(0, "public") || p.is(0, "weak") || p.is(0, "option")) && p.peek(1).Kind == TokenString {
+				imp.Modifier = p.next().Text
+			}
+			path, span, err := p.stringLiteral()
+			if err != nil {
+				return err
+			}
+			end, err := p.expect(";")
+			if err != nil {
+				return err
+			}
+			imp.Path, imp.PathSpan, imp.Span = path, span, Span{tok.Span.Start, end.Span.End}
+			file.Imports = append(file.Imports, imp)
+			file.Decls = append(file.Decls, imp)
+		case "option":
+			opt, err := p.parseOptionStatement()
+			if err != nil {
+				return err
+			}
+			file.Options = append(file.Options, opt)
+			file.Decls = append(file.Decls, opt)
+		case "message":
+			msg, err := p.parseMessage()
+			if err != nil {
+				return err
+			}
+			file.Messages = append(file.Messages, msg)
+			file.Decls = append(file.Decls, msg)
+		case "enum":
+			enum, err := p.parseEnum()
+			if err != nil {
+				return err
+			}
+			file.Enums = append(file.Enums, enum)
+			file.Decls = append(file.Decls, enum)
+		case "service":
+			service, err := p.parseService()
+			if err != nil {
+				return err
+			}
+			file.Services = append(file.Services, service)
+			file.Decls = append(file.Decls, service)
+		case "extend":
+			extend, err := p.parseExtend()
+			if err != nil {
+				return err
+			}
+			file.Extends = append(file.Extends, extend)
+			file.Decls = append(file.Decls, extend)
+		default:
+			return p.unexpected("expected a declaration")
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseOptionStatement() (*Option, error) {
+	start := p.next()
+	opt, err := p.parseOption()
+	if err != nil {
+		return nil, err
+	}
+	end, err := p.expect(";")
+	if err != nil {
+		return nil, err
+	}
+	opt.Span = Span{start.Span.Start, end.Span.End}
+	return opt, nil
+}
+
+// parseOption reads name = value, the span of the returned option covers them only.
+func (p *parser) parseOption() (*Option, error) {
+	opt := &Option{}
+	start := p.peek(0).Span.Start
+	var name strings.Builder
+	for {
+		if _, ok := p.accept("("); ok {
+			ext, span, err := p.fullIdent(true)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			name.WriteString("(" + ext + ")")
+			opt.Refs = append(opt.Refs, TypeRef{Name: ext, Span: span})
+		} else {
+			ident, _, err := p.fullIdent(false)
+			if err != nil {
+				return nil, err
+			}
+			name.WriteString(ident)
+		}
+		opt.NameSpan = Span{start, p.peek(-1).Span.End}
+		if _, ok := p.accept("."); !ok {
+			break
+		}
+		name.WriteByte('.')
+	}
+	opt.Name = name.String()
+
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+	if err := p.parseConstant(opt); err != nil {
+		return nil, err
+	}
+	opt.Span = Span{start, opt.ValueSpan.End}
+	return opt, nil
+}
+
+func (p *parser) parseConstant(opt *Option) error {
+	tok := p.peek(0)
+	switch {
+	case tok.Kind == TokenString:
+		value, span, err := p.stringLiteral()
+		if err != nil {
+			return err
+		}
+		opt.Value, opt.IsString, opt.ValueSpan = value, true, span
+		return nil
+	case p.is(0, "{"):
+		// message literal in the text format, kept as written
+		depth := 0
+		for !p.eof() {
+			t := p.next()
+			if t.Kind != TokenSymbol {
+				continue
+			}
+			if t.Text == "[" {
+				if ref, ok := p.aggregateRef(); ok {
+					opt.Refs = append(opt.Refs, ref)
+				}
+			} else if t.Text == "{" {
+				depth++
+			} else if t.Text == "}" {
+				depth--
+				if depth == 0 {
+					opt.ValueSpan = Span{tok.Span.Start, t.Span.End}
+					opt.Value = string(p.content[tok.Span.Start:t.Span.End])
+					return nil
+				}
+			}
+		}
+		return newError(p.content, tok.Span.Start, "unterminated message literal")
+	case p.is(0, "-") || p.is(0, "+"):
+		p.next()
+		value := p.peek(0)
+		if value.Kind != TokenInt && value.Kind != TokenFloat && value.Kind != TokenIdent {
+			return p.unexpected("expected a number")
+		}
+		p.next()
+		opt.ValueSpan = Span{tok.Span.Start, value.Span.End}
+	case tok.Kind == TokenInt || tok.Kind == TokenFloat:
+		p.next()
+		opt.ValueSpan = tok.Span
+	case tok.Kind == TokenIdent:
+		_, span, err := p.fullIdent(false)
+		if err != nil {
+			return err
+		}
+		opt.ValueSpan = span
+	default:
+		return p.unexpected("expected a constant")
+	}
+	opt.Value = string(p.content[opt.ValueSpan.Start:opt.ValueSpan.End])
+	return nil
+}
+
+// aggregateRef looks after the [ of a message literal for an extension name or an Any type URL, lists of values
+// aren't references.
+func (p *parser) aggregateRef() (TypeRef, bool) {
+	start := p.peek(0).Span.Start
+	var b strings.Builder
+	for i := 0; ; i++ {
+		tok := p.peek(i)
+		switch {
+		case tok.Kind == TokenIdent || tok.Text == ".":
+			b.WriteString(tok.Text)
+		case tok.Text == "/":
+			b.Reset()
+			start = tok.Span.End
+		case tok.Text == "]" && i > 0 && b.Len() > 0:
+			return TypeRef{Name: b.String(), Span: Span{start, p.peek(i - 1).Span.End}}, true
+		default:
+			return TypeRef{}, false
+		}
+	}
+}
+
+// parseCompactOptions reads [name = value, ...] when present.
+func (p *parser) parseCompactOptions() ([]*Option, error) {
+	if _, ok := p.accept("["); !ok {
+		return nil, nil
+	}
+	opts := make([]*Option, 0)
+	for {
+		opt, err := p.parseOption()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+		if _, ok := p.accept(","); !ok {
+			break
+		}
+	}
+	if _, err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// isDeclaration reports whether keyword starts a declaration of that kind rather than a field of a type named like it,
+// e.g. "message Foo {" but not "message foo = 1;".
+func (p *parser) isDeclaration(keyword string) bool {
+	return p.is(0, keyword) && p.peek(1).Kind == TokenIdent && !p.is(2, "=")
+}
+
+// isVisibility reports whether an edition 2024 visibility modifier starts a declaration of that kind,
+// e.g. "export message Foo {" but not "export message = 1;".
+func (p *parser) isVisibility(keyword string) bool {
+	return (p.is(0, "export") || p.is(0, "local")) && p.is(1, keyword) && p.peek(2).Kind == TokenIdent && p.is(3, "{")
+}
+
+// visibility reads the edition 2024 visibility modifier of a message or an enum, if any.
+func (p *parser) visibility() string {
+	if p.is(0, "export") || p.is(0, "local") {
+		return p.next().Text
+	}
+	return ""
+}
+
+func (p *parser) parseMessage() (*Message, error) {
+	start := p.peek(0)
+	visibility := p.visibility()
+	p.next()
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{Visibility: visibility, Name: name.Text, NameSpan: name.Span}
+	if err := p.parseMessageBody(msg); err != nil {
+		return nil, err
+	}
+	msg.Span = Span{start.Span.Start, msg.Body.Close + 1}
+	return msg, nil
+}
+
+func (p *parser) parseMessageBody(msg *Message) error {
+	open, err := p.expect("{")
+	if err != nil {
+		return err
+	}
+	msg.Body.Open = open.Span.Start
+
+	for {
+		if end, ok := p.accept("}"); ok {
+			msg.Body.Close = end.Span.Start
+			return nil
+		}
+		if p.eof() {
+			return p.unexpected("expected \"}\" to close message %s", msg.Name)
+		}
+		if tok, ok := p.accept(";"); ok {
+			msg.Decls = append(msg.Decls, &Statement{Keyword: ";", Span: tok.Span})
+			continue
+		}
+
+		switch {
+		case p.is(0, "option"):
+			opt, err := p.parseOptionStatement()
+			if err != nil {
+				return err
+			}
+			msg.Options = append(msg.Options, opt)
+			msg.Decls = append(msg.Decls, opt)
+		case p.isDeclaration("message") || p.isVisibility("message"):
+			nested, err := p.parseMessage()
+			if err != nil {
+				return err
+			}
+			msg.Messages = append(msg.Messages, nested)
+			msg.Decls = append(msg.Decls, nested)
+		case p.isDeclaration("enum") || p.isVisibility("enum"):
+			enum, err := p.parseEnum()
+			if err != nil {
+				return err
+			}
+			msg.Enums = append(msg.Enums, enum)
+			msg.Decls = append(msg.Decls, enum)
+		case p.isDeclaration("oneof"):
+			oneof, err := p.parseOneof()
+			if err != nil {
+				return err
+			}
+			msg.Oneofs = append(msg.Oneofs, oneof)
+			msg.Decls = append(msg.Decls, oneof)
+		case p.is(0, "extend") && !p.is(2, "="):
+			extend, err := p.parseExtend()
+			if err != nil {
+				return err
+			}
+			msg.Extends = append(msg.Extends, extend)
+			msg.Decls = append(msg.Decls, extend)
+		case (p.is(0, "reserved") || p.is(0, "extensions")) && !p.is(2, "="):
+			stmt, err := p.skipStatement(p.next())
+			if err != nil {
+				return err
+			}
+			msg.Decls = append(msg.Decls, stmt)
+		default:
+			field, err := p.parseField()
+			if err != nil {
+				return err
+			}
+			msg.Fields = append(msg.Fields, field)
+			msg.Decls = append(msg.Decls, field)
+		}
+	}
+}
+
+func (p *parser) parseField() (*Field, error) {
+	start := p.peek(0).Span.Start
+	field := &Field{}
+
+	if (p.is(0, "optional") || p.is(0, "required") || p.is(0, "repeated")) && !p.is(2, "=") {
+		field.Label = p.next().Text
+	}
+
+	if p.is(0, "map") && p.is(1, "<") {
+		p.next()
+		p.next()
+		key, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(","); err != nil {
+			return nil, err
+		}
+		value, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(">"); err != nil {
+			return nil, err
+		}
+		field.KeyType, field.Type = &key, value
+	} else {
+		typ, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		field.Type = typ
+	}
+
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	field.Name, field.NameSpan = name.Text, name.Span
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+	number := p.peek(0)
+	if number.Kind != TokenInt {
+		return nil, p.unexpected("expected the field number")
+	}
+	p.next()
+	field.Number = number.Text
+
+	if field.Options, err = p.parseCompactOptions(); err != nil {
+		return nil, err
+	}
+
+	if field.Type.Name == "group" && p.is(0, "{") {
+		group := &Message{Name: field.Name, NameSpan: field.NameSpan, Group: true}
+		if err := p.parseMessageBody(group); err != nil {
+			return nil, err
+		}
+		group.Span = Span{start, group.Body.Close + 1}
+		field.Group = group
+		field.Span = group.Span
+		return field, nil
+	}
+
+	end, err := p.expect(";")
+	if err != nil {
+		return nil, err
+	}
+	field.Span = Span{start, end.Span.End}
+	return field, nil
+}
+
+func (p *parser) parseOneof() (*Oneof, error) {
+	start := p.next()
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	oneof := &Oneof{Name: name.Text, NameSpan: name.Span}
+	open, err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	oneof.Body.Open = open.Span.Start
+
+	for {
+		if end, ok := p.accept("}"); ok {
+			oneof.Body.Close = end.Span.Start
+			oneof.Span = Span{start.Span.Start, end.Span.End}
+			return oneof, nil
+		}
+		if p.eof() {
+			return nil, p.unexpected("expected \"}\" to close oneof %s", oneof.Name)
+		}
+		if tok, ok := p.accept(";"); ok {
+			oneof.Decls = append(oneof.Decls, &Statement{Keyword: ";", Span: tok.Span})
+			continue
+		}
+		if p.is(0, "option") {
+			opt, err := p.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			oneof.Options = append(oneof.Options, opt)
+			oneof.Decls = append(oneof.Decls, opt)
+			continue
+		}
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		oneof.Fields = append(oneof.Fields, field)
+		oneof.Decls = append(oneof.Decls, field)
+	}
+}
+
+func (p *parser) parseEnum() (*Enum, error) {
+	start := p.peek(0)
+	visibility := p.visibility()
+	p.next()
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	enum := &Enum{Visibility: visibility, Name: name.Text, NameSpan: name.Span}
+	open, err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	enum.Body.Open = open.Span.Start
+
+	for {
+		if end, ok := p.accept("}"); ok {
+			enum.Body.Close = end.Span.Start
+			enum.Span = Span{start.Span.Start, end.Span.End}
+			return enum, nil
+		}
+		if p.eof() {
+			return nil, p.unexpected("expected \"}\" to close enum %s", enum.Name)
+		}
+		if tok, ok := p.accept(";"); ok {
+			enum.Decls = append(enum.Decls, &Statement{Keyword: ";", Span: tok.Span})
+			continue
+		}
+
+		switch {
+		case p.is(0, "option") && !p.is(1, "="):
+			opt, err := p.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			enum.Options = append(enum.Options, opt)
+			enum.Decls = append(enum.Decls, opt)
+		case p.is(0, "reserved") && !p.is(1, "="):
+			stmt, err := p.skipStatement(p.next())
+			if err != nil {
+				return nil, err
+			}
+			enum.Decls = append(enum.Decls, stmt)
+		default:
+			value, err := p.parseEnumValue()
+			if err != nil {
+				return nil, err
+			}
+			enum.Values = append(enum.Values, value)
+			enum.Decls = append(enum.Decls, value)
+		}
+	}
+}
+
+func (p *parser) parseEnumValue() (*EnumValue, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	value := &EnumValue{Name: name.Text, NameSpan: name.Span}
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+	numberStart := p.peek(0).Span.Start
+	p.accept("-")
+	if p.peek(0).Kind != TokenInt {
+		return nil, p.unexpected("expected the value number")
+	}
+	number := p.next()
+	value.Number = string(p.content[numberStart:number.Span.End])
+
+	if value.Options, err = p.parseCompactOptions(); err != nil {
+		return nil, err
+	}
+	end, err := p.expect(";")
+	if err != nil {
+		return nil, err
+	}
+	value.Span = Span{name.Span.Start, end.Span.End}
+	return value, nil
+}
+
+func (p *parser) parseService() (*Service, error) {
+	start := p.next()
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	service := &Service{Name: name.Text, NameSpan: name.Span}
+	open, err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	service.Body.Open = open.Span.Start
+
+	for {
+		if end, ok := p.accept("}"); ok {
+			service.Body.Close = end.Span.Start
+			service.Span = Span{start.Span.Start, end.Span.End}
+			return service, nil
+		}
+		if p.eof() {
+			return nil, p.unexpected("expected \"}\" to close service %s", service.Name)
+		}
+		if tok, ok := p.accept(";"); ok {
+			service.Decls = append(service.Decls, &Statement{Keyword: ";", Span: tok.Span})
+			continue
+		}
+
+		switch {
+		case p.is(0, "option"):
+			opt, err := p.parseOptionStatement()
+			if err != nil {
+				return nil, err
+			}
+			service.Options = append(service.Options, opt)
+			service.Decls = append(service.Decls, opt)
+		case p.is(0, "rpc"):
+			rpc, err := p.parseRPC()
+			if err != nil {
+				return nil, err
+			}
+			service.RPCs = append(service.RPCs, rpc)
+			service.Decls = append(service.Decls, rpc)
+		default:
+			return nil, p.unexpected("expected an option or rpc")
+		}
+	}
+}
+
+func (p *parser) parseRPC() (*RPC, error) {
+	start := p.next()
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	rpc := &RPC{Name: name.Text, NameSpan: name.Span}
+
+	rpcType := func() (TypeRef, bool, error) {
+		if _, err := p.expect("("); err != nil {
+			return TypeRef{}, false, err
+		}
+		stream := false
+		// stream.Foo is a type named stream.Foo, stream .Foo a stream of .Foo
+		dotted := p.is(1, ".") && p.peek(1).Span.Start == p.peek(0).Span.End
+		if p.is(0, "stream") && !p.is(1, ")") && !dotted {
+			p.next()
+			stream = true
+		}
+		typ, err := p.typeRef()
+		if err != nil {
+			return TypeRef{}, false, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return TypeRef{}, false, err
+		}
+		return typ, stream, nil
+	}
+
+	if rpc.Input, rpc.InputStream, err = rpcType(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("returns"); err != nil {
+		return nil, err
+	}
+	if rpc.Output, rpc.OutputStream, err = rpcType(); err != nil {
+		return nil, err
+	}
+
+	if end, ok := p.accept(";"); ok {
+		rpc.Span = Span{start.Span.Start, end.Span.End}
+		return rpc, nil
+	}
+	open, err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	rpc.Body = &Block{Open: open.Span.Start}
+	for {
+		if end, ok := p.accept("}"); ok {
+			rpc.Body.Close = end.Span.Start
+			rpc.Span = Span{start.Span.Start, end.Span.End}
+			// an optional ; may follow the body
+			if p.is(0, ";") {
+				rpc.Span.End = p.next().Span.End
+			}
+			return rpc, nil
+		}
+		if p.eof() {
+			return nil, p.unexpected("expected \"}\" to close rpc %s", rpc.Name)
+		}
+		if _, ok := p.accept(";"); ok {
+			continue
+		}
+		if !p.is(0, "option") {
+			return nil, p.unexpected("expected an option")
+		}
+		opt, err := p.parseOptionStatement()
+		if err != nil {
+			return nil, err
+		}
+		rpc.Options = append(rpc.Options, opt)
+	}
+}
+
+func (p *parser) parseExtend() (*Extend, error) {
+	start := p.next()
+	typ, err := p.typeRef()
+	if err != nil {
+		return nil, err
+	}
+	extend := &Extend{Type: typ}
+	open, err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+	extend.Body.Open = open.Span.Start
+
+	for {
+		if end, ok := p.accept("}"); ok {
+			extend.Body.Close = end.Span.Start
+			extend.Span = Span{start.Span.Start, end.Span.End}
+			return extend, nil
+		}
+		if p.eof() {
+			return nil, p.unexpected("expected \"}\" to close extend %s", extend.Type.Name)
+		}
+		if tok, ok := p.accept(";"); ok {
+			extend.Decls = append(extend.Decls, &Statement{Keyword: ";", Span: tok.Span})
+			continue
+		}
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		extend.Fields = append(extend.Fields, field)
+		extend.Decls = append(extend.Decls, field)
+	}
+}
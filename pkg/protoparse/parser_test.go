@@ -0,0 +1,233 @@
+package protoparse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const proto2Content = `// Copyright {the authors}
+syntax = "proto2";
+
+package acme.
+    billing.v1;
+
+import public "acme/common/money.proto";
+import weak "acme/common/debug.proto";
+import "google/protobuf/" "timestamp.proto";
+
+option java_package = "com.acme.billing";
+option (acme.file_ext) = { name: "a}b" tags: [1, 2] };
+
+/* messages { with } braces
+   in comments */
+message Invoice { option (.acme.derived_from) = "acme.Invoice"; optional string id = 1; }
+
+message
+    Payment
+{
+  // a } in a comment
+  optional string note = 1 [default = "{not a block}", deprecated = true];
+  required .acme.common.Money amount = 2;
+  map<string, google.protobuf.Timestamp> history = 3;
+  repeated group Line = 4 {
+    optional int32 quantity = 5;
+  }
+  enum State {
+    option allow_alias = true;
+    STATE_UNKNOWN = 0;
+    STATE_REFUNDED = -1 [(acme.label) = "refunded"];
+    reserved 2 to 3;
+  }
+  oneof method {
+    string card = 6;
+    Wire wire = 7;
+  }
+  message Wire { optional string iban = 1; }
+  extensions 100 to max;
+  reserved "legacy";
+}
+
+extend Payment {
+  optional string audit = 100;
+}
+
+service Billing {
+  option deprecated = false;
+  rpc Pay (Payment) returns (stream Invoice);
+  rpc Refund(stream .acme.billing.v1.Payment) returns (Invoice) {
+    option idempotency_level = IDEMPOTENT;
+  };
+}
+`
+
+func TestParseProto2(t *testing.T) {
+	file, err := Parse([]byte(proto2Content))
+	require.NoError(t, err)
+	text := func(span Span) string { return proto2Content[span.Start:span.End] }
+
+	require.Equal(t, "proto2", file.Syntax)
+	require.Equal(t, "acme.billing.v1", file.Package.Name)
+	require.Equal(t, "acme.\n    billing.v1", text(file.Package.NameSpan))
+
+	require.Len(t, file.Imports, 3)
+	require.Equal(t, "public", file.Imports[0].Modifier)
+	require.Equal(t, "weak", file.Imports[1].Modifier)
+	require.Equal(t, "google/protobuf/timestamp.proto", file.Imports[2].Path)
+	require.Equal(t, `"google/protobuf/" "timestamp.proto"`, text(file.Imports[2].PathSpan))
+
+	require.Len(t, file.Options, 2)
+	require.Equal(t, "java_package", file.Options[0].Name)
+	require.Equal(t, "com.acme.billing", file.Options[0].Value)
+	require.True(t, file.Options[0].IsString)
+	require.Equal(t, "(acme.file_ext)", file.Options[1].Name)
+	require.Equal(t, `{ name: "a}b" tags: [1, 2] }`, file.Options[1].Value)
+	// lists of values aren't references
+	require.Len(t, file.Options[1].Refs, 1)
+	require.Equal(t, "acme.file_ext", text(file.Options[1].Refs[0].Span))
+
+	require.Len(t, file.Messages, 2)
+	invoice := file.Messages[0]
+	require.Equal(t, "Invoice", invoice.Name)
+	require.Equal(t, "(.acme.derived_from)", invoice.Options[0].Name)
+	require.Equal(t, "acme.Invoice", invoice.Options[0].Value)
+	require.Len(t, invoice.Fields, 1)
+
+	payment := file.Messages[1]
+	require.Equal(t, "Payment", payment.Name)
+	require.Equal(t, "{", text(Span{payment.Body.Open, payment.Body.Open + 1}))
+	require.Equal(t, "}", text(Span{payment.Body.Close, payment.Body.Close + 1}))
+	// oneof fields are in the oneof
+	require.Len(t, payment.Fields, 4)
+	require.Equal(t, "{not a block}", payment.Fields[0].Options[0].Value)
+	require.Equal(t, "deprecated", payment.Fields[0].Options[1].Name)
+	require.Equal(t, "required", payment.Fields[1].Label)
+	require.Equal(t, ".acme.common.Money", payment.Fields[1].Type.Name)
+	require.Equal(t, "string", payment.Fields[2].KeyType.Name)
+	require.Equal(t, "google.protobuf.Timestamp", payment.Fields[2].Type.Name)
+	require.Equal(t, "Line", payment.Fields[3].Group.Name)
+	require.True(t, payment.Fields[3].Group.Group)
+	require.Len(t, payment.Fields[3].Group.Fields, 1)
+	require.Len(t, payment.Enums, 1)
+	require.Equal(t, []string{"0", "-1"}, []string{payment.Enums[0].Values[0].Number, payment.Enums[0].Values[1].Number})
+	require.Len(t, payment.Oneofs, 1)
+	require.Equal(t, "Wire", payment.Oneofs[0].Fields[1].Type.Name)
+	require.Equal(t, "Wire", payment.Messages[0].Name)
+
+	require.Equal(t, "Payment", file.Extends[0].Type.Name)
+	require.Equal(t, "audit", file.Extends[0].Fields[0].Name)
+
+	service := file.Services[0]
+	require.Len(t, service.RPCs, 2)
+	require.True(t, service.RPCs[0].OutputStream)
+	require.Nil(t, service.RPCs[0].Body)
+	require.True(t, service.RPCs[1].InputStream)
+	require.Equal(t, ".acme.billing.v1.Payment", service.RPCs[1].Input.Name)
+	require.Equal(t, "IDEMPOTENT", service.RPCs[1].Options[0].Value)
+	require.Equal(t, "};", text(service.RPCs[1].Span)[len(text(service.RPCs[1].Span))-2:])
+
+	require.Len(t, file.Comments, 3)
+}
+
+func TestParseEditions(t *testing.T) {
+	content := `edition = "2023";
+package acme;
+option features.field_presence = IMPLICIT;
+message A { int32 a = 1 [features.field_presence = EXPLICIT]; }
+`
+	file, err := Parse([]byte(content))
+	require.NoError(t, err)
+	require.Equal(t, "", file.Syntax)
+	require.Equal(t, "2023", file.Edition)
+	require.Equal(t, "features.field_presence", file.Options[0].Name)
+	require.Equal(t, "features.field_presence", file.Messages[0].Fields[0].Options[0].Name)
+}
+
+func TestParseEdition2024(t *testing.T) {
+	content := `edition = "2024";
+package acme;
+import option "acme/options.proto";
+export message A {
+  local enum Kind { KIND_UNSPECIFIED = 0; }
+  export message B {}
+  export export = 1;
+  local local = 2;
+}
+local enum State { STATE_UNSPECIFIED = 0; }
+`
+	file, err := Parse([]byte(content))
+	require.NoError(t, err)
+	require.Equal(t, "option", file.Imports[0].Modifier)
+	require.Equal(t, "acme/options.proto", file.Imports[0].Path)
+	msg := file.Messages[0]
+	require.Equal(t, "export", msg.Visibility)
+	require.Equal(t, "A", msg.Name)
+	require.Equal(t, strings.Index(content, "export message A"), msg.Span.Start)
+	require.Equal(t, "local", msg.Enums[0].Visibility)
+	require.Equal(t, "export", msg.Messages[0].Visibility)
+	require.Len(t, msg.Fields, 2)
+	require.Equal(t, "export", msg.Fields[0].Type.Name)
+	require.Equal(t, "local", file.Enums[0].Visibility)
+	require.Equal(t, "State", file.Enums[0].Name)
+}
+
+func TestParseFieldsNamedLikeKeywords(t *testing.T) {
+	file, err := Parse([]byte(`syntax = "proto3";
+message message { message message = 1; enum enum = 2; optional optional = 3; map<string, string> map = 4; }`))
+	require.NoError(t, err)
+	msg := file.Messages[0]
+	require.Len(t, msg.Fields, 4)
+	require.Equal(t, "message", msg.Fields[0].Type.Name)
+	require.Equal(t, "enum", msg.Fields[1].Type.Name)
+	require.Equal(t, "", msg.Fields[2].Label)
+	require.Equal(t, "optional", msg.Fields[2].Type.Name)
+	require.Equal(t, "optional", msg.Fields[2].Name)
+}
+
+func TestParseOptionRefs(t *testing.T) {
+	content := `option (.a.ext).field = { [a.other]: 1 any { [type.googleapis.com/a.B] { x: [2] } } };`
+	file, err := Parse([]byte(content))
+	require.NoError(t, err)
+	refs := make([]string, 0)
+	for _, ref := range file.Options[0].Refs {
+		require.Equal(t, ref.Name, content[ref.Span.Start:ref.Span.End])
+		refs = append(refs, ref.Name)
+	}
+	require.Equal(t, []string{".a.ext", "a.other", "a.B"}, refs)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, content := range []string{
+		`message A {`,
+		`message A { string a = ; }`,
+		`syntax = "proto3`,
+		`/* unterminated`,
+		`message A { option (a = 1; }`,
+		`service S { rpc A(B) C; }`,
+	} {
+		_, err := Parse([]byte(content))
+		require.Error(t, err, content)
+	}
+
+	_, err := Parse([]byte("syntax = \"proto3\";\n\nmessage A {\n  string a = ;\n}\n"))
+	require.EqualError(t, err, `4:14: expected the field number, found ";"`)
+}
+
+func TestWalk(t *testing.T) {
+	file, err := Parse([]byte(proto2Content))
+	require.NoError(t, err)
+
+	names := make([]string, 0)
+	file.Walk(func(node Node, parents []Node) bool {
+		if msg, ok := node.(*Message); ok {
+			if scope := Scope(parents); scope != "" {
+				names = append(names, scope+"."+msg.Name)
+			} else {
+				names = append(names, msg.Name)
+			}
+		}
+		return true
+	})
+	require.Equal(t, []string{"Invoice", "Payment", "Payment.Line", "Payment.Wire"}, names)
+}
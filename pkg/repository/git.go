@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 
 	"github.com/stormcat24/protodep/pkg/auth"
 	"github.com/stormcat24/protodep/pkg/config"
@@ -19,87 +21,112 @@ type Git interface {
 }
 
 type github struct {
-	protodepDir  string
-	dep          config.ProtoDepDependency
-	authProvider auth.AuthProvider
+	protodepDir   string
+	dep           config.ProtoDepDependency
+	authProvider  auth.AuthProvider
+	submoduleAuth SubmoduleAuthFunc
+	keyring       string
 }
 
-func NewGit(protodepDir string, dep config.ProtoDepDependency, authProvider auth.AuthProvider) Git {
-	return &github{
+// SubmoduleAuthFunc selects the AuthProvider for a submodule repository, e.g. github.com/org/common
+type SubmoduleAuthFunc func(reponame string) (auth.AuthProvider, error)
+
+type GitOption func(*github)
+
+// WithSubmoduleAuth sets how submodules are authenticated. By default they use the AuthProvider of the dependency.
+func WithSubmoduleAuth(f SubmoduleAuthFunc) GitOption {
+	return func(r *github) {
+		r.submoduleAuth = f
+	}
+}
+
+// WithSignatureKeyring sets the armored PGP keyring or ssh allowed signers used when the dependency requires a signature.
+func WithSignatureKeyring(keyring string) GitOption {
+	return func(r *github) {
+		r.keyring = keyring
+	}
+}
+
+func NewGit(protodepDir string, dep config.ProtoDepDependency, authProvider auth.AuthProvider, opts ...GitOption) Git {
+	r := &github{
 		protodepDir:  protodepDir,
 		dep:          dep,
 		authProvider: authProvider,
 	}
+	r.submoduleAuth = func(string) (auth.AuthProvider, error) {
+		return r.authProvider, nil
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
 }
 
 type OpenedRepository struct {
 	Repository *git.Repository
 	Dep        config.ProtoDepDependency
 	Hash       string
+	// Branch is the configured branch, or the remote default branch when none is configured.
+	Branch string
+	// Signer describes who signed the revision, only set when the dependency requires a signature.
+	Signer string
+	// SignedTag is the tag whose signature was verified, empty when it was the signature of the commit.
+	SignedTag string
 }
 
 func (r *github) Open() (*OpenedRepository, error) {
-
-	branch := "master"
-	if r.dep.Branch != "" {
-		branch = r.dep.Branch
-	}
-
-	revision := r.dep.Revision
-
 	reponame := r.dep.Repository()
 	repopath := filepath.Join(r.protodepDir, reponame)
 
-	auth, err := r.authProvider.AuthMethod()
-	if err != nil {
-		return nil, err
-	}
+	urls := auth.RepositoryURLs(r.authProvider, reponame)
 
 	var rep *git.Repository
+	var am transport.AuthMethod
 
 	if stat, err := os.Stat(repopath); err == nil && stat.IsDir() {
 		spinner := logger.InfoWithSpinner("Getting %s ", reponame)
 
 		rep, err = git.PlainOpen(repopath)
+		if err == nil {
+			err = r.syncRemote(rep, reponame, urls[0])
+		}
 		if err != nil {
-			return nil, fmt.Errorf("open repository: %w", err)
+			spinner.Stop()
+			logger.Warn("cached repository %s is unusable, cloning it again: %v", reponame, err)
+			return r.reclone(repopath, urls)
 		}
 		spinner.Stop()
 
-		fetchOpts := &git.FetchOptions{
-			Auth: auth,
-		}
-
-		// TODO: Validate remote setting.
-		// TODO: If .protodep cache remains with SSH, change remote target to HTTPS.
-
-		if err := rep.Fetch(fetchOpts); err != nil {
-			if err != git.NoErrAlreadyUpToDate {
-				return nil, fmt.Errorf("fetch repository: %w", err)
-			}
+		am, err = r.fetch(rep, urls)
+		if err != nil {
+			return nil, err
 		}
 		spinner.Finish()
 
 	} else {
-		spinner := logger.InfoWithSpinner("Getting %s ", reponame)
-		// IDEA: Is it better to register both ssh and HTTP?
-		rep, err = git.PlainClone(repopath, false, &git.CloneOptions{
-			Auth: auth,
-			URL:  r.authProvider.GetRepositoryURL(reponame),
-		})
+		rep, am, err = r.clone(repopath, urls)
 		if err != nil {
-			return nil, fmt.Errorf("clone repository: %w", err)
+			return nil, err
 		}
-		spinner.Finish()
 	}
 
+	return r.checkout(rep, am)
+}
+
+func (r *github) checkout(rep *git.Repository, auth transport.AuthMethod) (*OpenedRepository, error) {
+	branch := r.dep.Branch
+	revision := r.dep.Revision
+	// a locked revision is a hash, the tag verified when it was locked is verified again
+	tagName := r.dep.SignedTag
+
 	wt, err := rep.Worktree()
 	if err != nil {
 		return nil, fmt.Errorf("get worktree: %w", err)
 	}
 
 	if revision == "" {
-		target, err := r.resolveReference(rep, branch)
+		var target *plumbing.Reference
+		branch, target, err = r.resolveReference(rep, auth, branch)
 		if err != nil {
 			return nil, fmt.Errorf("change branch to %s: %w", branch, err)
 		}
@@ -113,6 +140,12 @@ func (r *github) Open() (*OpenedRepository, error) {
 			return nil, fmt.Errorf("set head to %s: %w", branch, err)
 		}
 	} else {
+		if branch != "" {
+			if _, err := r.getReference(rep, branch); err == plumbing.ErrReferenceNotFound {
+				logger.Warn("branch %s of %s no longer exists on the remote", branch, r.dep.Repository())
+			}
+		}
+
 		var opts git.CheckoutOptions
 
 		tag := plumbing.NewTagReferenceName(revision)
@@ -128,12 +161,30 @@ func (r *github) Open() (*OpenedRepository, error) {
 			} else {
 				logger.Info("%s is a tag, checking out by tag", revision)
 				opts = git.CheckoutOptions{Branch: tag}
+				tagName = revision
 			}
 		}
 
 		if err := wt.Checkout(&opts); err != nil {
-			return nil, fmt.Errorf( "checkout to %s: %w", revision, err)
+			return nil, fmt.Errorf("checkout to %s: %w", revision, err)
+		}
+	}
+
+	var signer, signedTag string
+	if r.dep.RequireSignature {
+		signer, signedTag, err = verifySignature(rep, tagName, r.keyring)
+		if err != nil {
+			return nil, fmt.Errorf("refusing to vendor %s: %w", r.dep.Repository(), err)
 		}
+		logger.Info("%s is signed by %s", r.dep.Repository(), signer)
+	}
+
+	if r.dep.Submodules {
+		if err := r.updateSubmodules(rep, r.dep.Repository(), git.DefaultSubmoduleRecursionDepth); err != nil {
+			return nil, err
+		}
+	} else {
+		r.warnUninitializedSubmodules(wt)
 	}
 
 	commiter, err := rep.Log(&git.LogOptions{})
@@ -150,26 +201,178 @@ func (r *github) Open() (*OpenedRepository, error) {
 		Repository: rep,
 		Dep:        r.dep,
 		Hash:       current.Hash.String(),
+		Branch:     branch,
+		Signer:     signer,
+		SignedTag:  signedTag,
 	}, nil
 }
 
+// clone tries the urls in order until one succeeds, each url gets the credentials of its own host.
+func (r *github) clone(repopath string, urls []string) (*git.Repository, transport.AuthMethod, error) {
+	var err error
+	for i, url := range urls {
+		if i > 0 {
+			logger.Warn("cloning %s from %s failed, falling back to %s: %v", r.dep.Repository(), urls[i-1], url, err)
+			if err := os.RemoveAll(repopath); err != nil {
+				return nil, nil, fmt.Errorf("remove cached repository: %w", err)
+			}
+		}
+
+		var am transport.AuthMethod
+		am, err = auth.URLAuthMethod(r.authProvider, r.dep.Repository(), url)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		spinner := logger.InfoWithSpinner("Getting %s ", r.dep.Repository())
+		// IDEA: Is it better to register both ssh and HTTP?
+		var rep *git.Repository
+		rep, err = git.PlainClone(repopath, false, &git.CloneOptions{
+			Auth: am,
+			URL:  url,
+		})
+		if err == nil {
+			spinner.Finish()
+			return rep, am, nil
+		}
+		// the failure is logged with the next url, or returned
+		spinner.Stop()
+	}
+	return nil, nil, fmt.Errorf("clone repository: %w", err)
+}
+
+// fetch tries the urls in order until one succeeds, origin is left pointing to the one which worked.
+// It returns the credentials of that url.
+func (r *github) fetch(rep *git.Repository, urls []string) (transport.AuthMethod, error) {
+	var err error
+	for i, url := range urls {
+		if i > 0 {
+			logger.Warn("fetching %s from %s failed, falling back to %s: %v", r.dep.Repository(), urls[i-1], url, err)
+			if err := r.syncRemote(rep, r.dep.Repository(), url); err != nil {
+				return nil, err
+			}
+		}
+
+		var am transport.AuthMethod
+		am, err = auth.URLAuthMethod(r.authProvider, r.dep.Repository(), url)
+		if err != nil {
+			return nil, err
+		}
+
+		// prune the remote branches deleted since the cache fetched them, so they can't be checked out
+		err = rep.Fetch(&git.FetchOptions{
+			Auth:  am,
+			Prune: true,
+		})
+		if err == nil || err == git.NoErrAlreadyUpToDate {
+			return am, nil
+		}
+	}
+	return nil, fmt.Errorf("fetch repository: %w", err)
+}
+
+// reclone drops the cached copy of this repository only and clones it from scratch.
+func (r *github) reclone(repopath string, urls []string) (*OpenedRepository, error) {
+	if err := os.RemoveAll(repopath); err != nil {
+		return nil, fmt.Errorf("remove cached repository: %w", err)
+	}
+	rep, am, err := r.clone(repopath, urls)
+	if err != nil {
+		return nil, err
+	}
+	return r.checkout(rep, am)
+}
+
+// syncRemote points origin to url when the cache was cloned with another protocol or location.
+func (r *github) syncRemote(rep *git.Repository, reponame string, url string) error {
+	cfg, err := rep.Config()
+	if err != nil {
+		return fmt.Errorf("read repository config: %w", err)
+	}
+
+	remote, ok := cfg.Remotes[git.DefaultRemoteName]
+	if !ok {
+		return fmt.Errorf("remote %s not found", git.DefaultRemoteName)
+	}
+	if len(remote.URLs) == 1 && remote.URLs[0] == url {
+		return nil
+	}
+
+	logger.Info("remote of %s changed from %s to %s", reponame, strings.Join(remote.URLs, ", "), url)
+	remote.URLs = []string{url}
+	if err := rep.SetConfig(cfg); err != nil {
+		return fmt.Errorf("update remote %s: %w", git.DefaultRemoteName, err)
+	}
+	return nil
+}
+
 func (r *github) ProtoRootDir() string {
 	return filepath.Join(r.protodepDir, r.dep.Target)
 }
 
-func (r *github) resolveReference(rep *git.Repository, branch string) (*plumbing.Reference, error) {
-	if branch != "master" {
-		return r.getReference(rep, branch)
+// resolveReference returns the remote branch to check out, the remote default branch when none is configured.
+// A configured branch which no longer exists is an error rather than a reason to switch branches silently.
+func (r *github) resolveReference(rep *git.Repository, auth transport.AuthMethod, branch string) (string, *plumbing.Reference, error) {
+	if branch != "" {
+		target, err := r.getReference(rep, branch)
+		if err == plumbing.ErrReferenceNotFound {
+			return branch, nil, fmt.Errorf("branch %s of %s no longer exists on the remote, configure another branch or remove it to use the default branch", branch, r.dep.Repository())
+		}
+		return branch, target, err
 	}
-	// If master branch is failed, try main branch.
-	target, err := r.getReference(rep, branch)
-	if err == plumbing.ErrReferenceNotFound {
-		return r.getReference(rep, "main")
+
+	defaultBranch, err := r.defaultBranch(rep, auth)
+	if err != nil {
+		return branch, nil, err
 	}
+
+	target, err := r.getReference(rep, defaultBranch)
 	if err != nil {
-		return nil, err
+		return defaultBranch, nil, err
+	}
+	return defaultBranch, target, nil
+}
+
+// defaultBranch asks the remote where its HEAD points to and remembers it as refs/remotes/origin/HEAD,
+// which is used as fallback when the remote can't be listed.
+func (r *github) defaultBranch(rep *git.Repository, auth transport.AuthMethod) (string, error) {
+	remoteHead := plumbing.NewRemoteHEADReferenceName(git.DefaultRemoteName)
+
+	var branch string
+	remote, err := rep.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return "", fmt.Errorf("get remote %s: %w", git.DefaultRemoteName, err)
+	}
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		logger.Warn("failed to detect the default branch of %s: %v", r.dep.Repository(), err)
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference && ref.Target().IsBranch() {
+			branch = ref.Target().Short()
+		}
+	}
+
+	if branch == "" {
+		ref, err := rep.Storer.Reference(remoteHead)
+		if err == nil && ref.Type() == plumbing.SymbolicReference {
+			branch = strings.TrimPrefix(ref.Target().String(), fmt.Sprintf("refs/remotes/%s/", git.DefaultRemoteName))
+		}
+	}
+
+	if branch == "" {
+		// The remote doesn't tell, so guess like older versions did.
+		branch = "master"
+		if _, err := r.getReference(rep, branch); err == plumbing.ErrReferenceNotFound {
+			branch = "main"
+		}
+	}
+
+	ref := plumbing.NewSymbolicReference(remoteHead, plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branch))
+	if err := rep.Storer.SetReference(ref); err != nil {
+		return "", fmt.Errorf("set %s: %w", remoteHead, err)
 	}
-	return target, nil
+	return branch, nil
 }
 
 func (r *github) getReference(rep *git.Repository, branch string) (*plumbing.Reference, error) {
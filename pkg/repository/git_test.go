@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stormcat24/protodep/pkg/auth"
+	"github.com/stormcat24/protodep/pkg/config"
+)
+
+func TestSyncRemote(t *testing.T) {
+	rep, err := git.PlainInit(t.TempDir(), false)
+	require.NoError(t, err)
+
+	_, err = rep.CreateRemote(&gitconfig.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{"ssh://github.com/stormcat24/protodep.git"},
+	})
+	require.NoError(t, err)
+
+	target := &github{
+		dep: config.ProtoDepDependency{Target: "github.com/stormcat24/protodep"},
+	}
+
+	require.NoError(t, target.syncRemote(rep, "github.com/stormcat24/protodep", "https://github.com/stormcat24/protodep.git"))
+
+	remote, err := rep.Remote(git.DefaultRemoteName)
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://github.com/stormcat24/protodep.git"}, remote.Config().URLs)
+
+	// unchanged remote is left as is
+	require.NoError(t, target.syncRemote(rep, "github.com/stormcat24/protodep", "https://github.com/stormcat24/protodep.git"))
+}
+
+func TestSyncRemoteWithoutOrigin(t *testing.T) {
+	rep, err := git.PlainInit(t.TempDir(), false)
+	require.NoError(t, err)
+
+	target := &github{
+		dep: config.ProtoDepDependency{Target: "github.com/stormcat24/protodep"},
+	}
+	require.Error(t, target.syncRemote(rep, "github.com/stormcat24/protodep", "https://github.com/stormcat24/protodep.git"))
+}
+
+func TestOpenDefaultBranch(t *testing.T) {
+	upstream := initUpstream(t, "develop")
+
+	// a stale master next to the real default branch must not be picked
+	head, err := upstream.Head()
+	require.NoError(t, err)
+	require.NoError(t, upstream.Storer.SetReference(plumbing.NewHashReference(plumbing.Master, head.Hash())))
+	latest := commitFile(t, upstream, "api.proto", "syntax = \"proto3\";\npackage api;\n")
+
+	opened := openDependency(t, config.ProtoDepDependency{Target: "example.com/org/api"}, upstream)
+	require.Equal(t, "develop", opened.Branch)
+	require.Equal(t, latest.String(), opened.Hash)
+}
+
+func TestOpenMissingBranch(t *testing.T) {
+	upstream := initUpstream(t, "trunk")
+
+	_, err := openDependencyAt(t, t.TempDir(), config.ProtoDepDependency{Target: "example.com/org/api", Branch: "master"}, upstream)
+	require.ErrorContains(t, err, "branch master of example.com/org/api no longer exists")
+}
+
+func TestOpenDeletedBranch(t *testing.T) {
+	upstream := initUpstream(t, "trunk")
+	head, err := upstream.Head()
+	require.NoError(t, err)
+	require.NoError(t, upstream.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("feature"), head.Hash())))
+
+	dir := t.TempDir()
+	dep := config.ProtoDepDependency{Target: "example.com/org/api", Branch: "feature"}
+	opened, err := openDependencyAt(t, dir, dep, upstream)
+	require.NoError(t, err)
+	require.Equal(t, "feature", opened.Branch)
+
+	// the branch left in the cached clone must not hide the deletion
+	require.NoError(t, upstream.Storer.RemoveReference(plumbing.NewBranchReferenceName("feature")))
+	_, err = openDependencyAt(t, dir, dep, upstream)
+	require.ErrorContains(t, err, "branch feature of example.com/org/api no longer exists")
+}
+
+func TestOpenCorruptCache(t *testing.T) {
+	upstream := initUpstream(t, "main")
+	latest := commitFile(t, upstream, "api.proto", "syntax = \"proto3\";\npackage api;\n")
+
+	dir := t.TempDir()
+	dep := config.ProtoDepDependency{Target: "example.com/org/api"}
+	_, err := openDependencyAt(t, dir, dep, upstream)
+	require.NoError(t, err)
+
+	repopath := filepath.Join(dir, dep.Repository())
+	require.NoError(t, os.WriteFile(filepath.Join(repopath, ".git", "stale"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(repopath, ".git", "config"), []byte("[remote \"origin"), 0644))
+
+	// only the broken repository is cloned again
+	opened, err := openDependencyAt(t, dir, dep, upstream)
+	require.NoError(t, err)
+	require.Equal(t, latest.String(), opened.Hash)
+	require.NoFileExists(t, filepath.Join(repopath, ".git", "stale"))
+}
+
+func TestOpenChangedRemote(t *testing.T) {
+	upstream := initUpstream(t, "main")
+
+	dir := t.TempDir()
+	dep := config.ProtoDepDependency{Target: "example.com/org/api"}
+	opened, err := openDependencyAt(t, dir, dep, upstream)
+	require.NoError(t, err)
+
+	// the cache was cloned over ssh before the protocol changed
+	cfg, err := opened.Repository.Config()
+	require.NoError(t, err)
+	cfg.Remotes[git.DefaultRemoteName].URLs = []string{"ssh://git@example.com/org/api.git"}
+	require.NoError(t, opened.Repository.SetConfig(cfg))
+
+	repopath := filepath.Join(dir, dep.Repository())
+	require.NoError(t, os.WriteFile(filepath.Join(repopath, ".git", "kept"), nil, 0644))
+	latest := commitFile(t, upstream, "api.proto", "syntax = \"proto3\";\npackage api;\n")
+
+	opened, err = openDependencyAt(t, dir, dep, upstream)
+	require.NoError(t, err)
+	require.Equal(t, latest.String(), opened.Hash)
+	require.FileExists(t, filepath.Join(repopath, ".git", "kept"))
+
+	remote, err := opened.Repository.Remote(git.DefaultRemoteName)
+	require.NoError(t, err)
+	require.Equal(t, []string{"file:///example.com/org/api"}, remote.Config().URLs)
+}
+
+func openDependency(t *testing.T, dep config.ProtoDepDependency, upstream *git.Repository) *OpenedRepository {
+	opened, err := openDependencyAt(t, t.TempDir(), dep, upstream)
+	require.NoError(t, err)
+	return opened
+}
+
+func openDependencyAt(t *testing.T, protodepDir string, dep config.ProtoDepDependency, upstream *git.Repository, opts ...GitOption) (*OpenedRepository, error) {
+	url := "file:///" + dep.Repository()
+	ep, err := transport.NewEndpoint(url)
+	require.NoError(t, err)
+	client.InstallProtocol("file", server.NewClient(server.MapLoader{ep.String(): upstream.Storer}))
+
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	provider := auth.NewMockAuthProvider(c)
+	provider.EXPECT().AuthMethod().Return(nil, nil).AnyTimes()
+	provider.EXPECT().GetRepositoryURL(dep.Repository()).Return(url).AnyTimes()
+
+	return NewGit(protodepDir, dep, provider, opts...).Open()
+}
+
+func TestOpenLockedSignedTag(t *testing.T) {
+	maintainer := newPGPEntity(t, "maintainer")
+	upstream := initUpstream(t, "main")
+	head, err := upstream.Head()
+	require.NoError(t, err)
+	_, err = upstream.CreateTag("v1.0.0", head.Hash(), &git.CreateTagOptions{
+		Tagger:  testSignature(),
+		Message: "release v1.0.0",
+		SignKey: maintainer,
+	})
+	require.NoError(t, err)
+	keyring := WithSignatureKeyring(armoredPublicKey(t, maintainer))
+
+	dir := t.TempDir()
+	dep := config.ProtoDepDependency{Target: "example.com/org/api", Revision: "v1.0.0", RequireSignature: true}
+	opened, err := openDependencyAt(t, dir, dep, upstream, keyring)
+	require.NoError(t, err)
+	require.Equal(t, "v1.0.0", opened.SignedTag)
+
+	// the lock pins the hash of the unsigned commit, the tag is verified again
+	locked := dep
+	locked.Revision = opened.Hash
+	locked.SignedTag = opened.SignedTag
+	relocked, err := openDependencyAt(t, dir, locked, upstream, keyring)
+	require.NoError(t, err)
+	require.Equal(t, opened.Signer, relocked.Signer)
+	require.Equal(t, "v1.0.0", relocked.SignedTag)
+}
+
+func initUpstream(t *testing.T, branch string) *git.Repository {
+	rep, err := git.PlainInit(t.TempDir(), false)
+	require.NoError(t, err)
+	require.NoError(t, rep.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branch))))
+
+	commitFile(t, rep, "api.proto", "syntax = \"proto3\";\n")
+	return rep
+}
+
+func commitFile(t *testing.T, rep *git.Repository, name string, content string) plumbing.Hash {
+	wt, err := rep.Worktree()
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(wt.Filesystem.Root(), name), []byte(content), 0644))
+	_, err = wt.Add(name)
+	require.NoError(t, err)
+
+	hash, err := wt.Commit("update "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "protodep", Email: "protodep@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	return hash
+}
+
+func TestSubmoduleRepository(t *testing.T) {
+	parent := "github.com/org/api"
+
+	cases := map[string]string{
+		"../common.git":                       "github.com/org/common",
+		"./nested":                            "github.com/org/api/nested",
+		"https://gitlab.com/group/sub/protos": "gitlab.com/group/sub/protos",
+		"ssh://git@github.com/org/shared.git": "github.com/org/shared",
+		"git@bitbucket.org:team/defs.git":     "bitbucket.org/team/defs",
+	}
+	for url, expected := range cases {
+		actual, err := submoduleRepository(parent, url)
+		require.NoError(t, err, url)
+		require.Equal(t, expected, actual, url)
+	}
+
+	_, err := submoduleRepository(parent, "../../../outside")
+	require.Error(t, err)
+	_, err = submoduleRepository(parent, "local-dir")
+	require.Error(t, err)
+}
+
+func TestProbe(t *testing.T) {
+	upstream := initUpstream(t, "main")
+	url := "file:///example.com/org/probed"
+	ep, err := transport.NewEndpoint(url)
+	require.NoError(t, err)
+	client.InstallProtocol("file", server.NewClient(server.MapLoader{ep.String(): upstream.Storer}))
+
+	c := gomock.NewController(t)
+	defer c.Finish()
+
+	provider := auth.NewMockAuthProvider(c)
+	provider.EXPECT().AuthMethod().Return(nil, nil).AnyTimes()
+	provider.EXPECT().GetRepositoryURL("example.com/org/probed").Return(url).AnyTimes()
+	provider.EXPECT().GetRepositoryURL("example.com/org/missing").Return("file:///example.com/org/missing").AnyTimes()
+
+	actual, err := Probe(provider, "example.com/org/probed")
+	require.NoError(t, err)
+	require.Equal(t, url, actual)
+
+	_, err = Probe(provider, "example.com/org/missing")
+	require.Error(t, err)
+}
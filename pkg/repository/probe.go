@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/stormcat24/protodep/pkg/auth"
+)
+
+// Probe lists the references of a repository like `git ls-remote` does, to check that the credentials of
+// authProvider grant access to it without cloning. It returns the url which answered.
+// Like when cloning, a rewritten url gets the credentials of its own host.
+func Probe(authProvider auth.AuthProvider, reponame string) (string, error) {
+	var err error
+	urls := auth.RepositoryURLs(authProvider, reponame)
+	for _, url := range urls {
+		var am transport.AuthMethod
+		am, err = auth.URLAuthMethod(authProvider, reponame, url)
+		if err != nil {
+			return "", err
+		}
+
+		remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{
+			Name: git.DefaultRemoteName,
+			URLs: []string{url},
+		})
+		_, err = remote.List(&git.ListOptions{Auth: am})
+		if err == nil {
+			return url, nil
+		}
+	}
+	return "", fmt.Errorf("list references of %s: %w", urls[len(urls)-1], err)
+}
@@ -0,0 +1,299 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	pgpSignaturePrefix  = "-----BEGIN PGP SIGNATURE-----"
+	sshSignaturePrefix  = "-----BEGIN SSH SIGNATURE-----"
+	pgpKeyringPrefix    = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+	sshSignatureMagic   = "SSHSIG"
+	sshSignatureVersion = 1
+	// git signs commits and tags within the "git" namespace, see `man ssh-keygen`.
+	sshSignatureNamespace = "git"
+)
+
+var errUnsigned = errors.New("revision is not signed")
+
+// verifySignature checks the signed tag named tagName, or the commit HEAD points to when there is no such tag, against keyring.
+// keyring is either an armored PGP public keyring or an ssh allowed signers file. It returns a description of the signer
+// and the name of the tag whose signature was verified, empty when the commit signature was verified.
+func verifySignature(rep *git.Repository, tagName string, keyring string) (string, string, error) {
+	head, err := rep.Head()
+	if err != nil {
+		return "", "", fmt.Errorf("get head: %w", err)
+	}
+
+	if tagName != "" {
+		tag, err := annotatedTag(rep, tagName)
+		if err != nil {
+			return "", "", err
+		}
+		if tag != nil && tag.PGPSignature != "" {
+			if tag.Target != head.Hash() {
+				return "", "", fmt.Errorf("tag %s points to %s, not to the checked out commit %s", tagName, tag.Target, head.Hash())
+			}
+			signer, err := verifyObject(keyring, tag.PGPSignature, tag.Tagger.Email, tag.EncodeWithoutSignature)
+			if err != nil {
+				return "", "", fmt.Errorf("tag %s: %w", tagName, err)
+			}
+			return signer, tagName, nil
+		}
+	}
+
+	commit, err := rep.CommitObject(head.Hash())
+	if err != nil {
+		return "", "", fmt.Errorf("get commit %s: %w", head.Hash(), err)
+	}
+	if commit.PGPSignature == "" {
+		return "", "", fmt.Errorf("commit %s: %w", commit.Hash, errUnsigned)
+	}
+
+	signer, err := verifyObject(keyring, commit.PGPSignature, commit.Committer.Email, commit.EncodeWithoutSignature)
+	if err != nil {
+		return "", "", fmt.Errorf("commit %s: %w", commit.Hash, err)
+	}
+	return signer, "", nil
+}
+
+func annotatedTag(rep *git.Repository, name string) (*object.Tag, error) {
+	ref, err := rep.Reference(plumbing.NewTagReferenceName(name), false)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := rep.TagObject(ref.Hash())
+	if err == plumbing.ErrObjectNotFound {
+		// lightweight tag
+		return nil, nil
+	}
+	return tag, err
+}
+
+// verifyObject checks the signature of an object made by identity, the email of its committer or tagger.
+func verifyObject(keyring string, signature string, identity string, encode func(plumbing.EncodedObject) error) (string, error) {
+	encoded := &plumbing.MemoryObject{}
+	if err := encode(encoded); err != nil {
+		return "", err
+	}
+	r, err := encoded.Reader()
+	if err != nil {
+		return "", err
+	}
+	message, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case strings.HasPrefix(signature, pgpSignaturePrefix):
+		return verifyPGPSignature(keyring, signature, message)
+	case strings.HasPrefix(signature, sshSignaturePrefix):
+		return verifySSHSignature(keyring, signature, identity, message)
+	default:
+		return "", errors.New("unsupported signature format")
+	}
+}
+
+func verifyPGPSignature(keyring string, signature string, message []byte) (string, error) {
+	if !strings.Contains(keyring, pgpKeyringPrefix) {
+		return "", errors.New("signed with PGP, but the keyring contains no PGP public keys")
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(keyring))
+	if err != nil {
+		return "", fmt.Errorf("read keyring: %w", err)
+	}
+
+	entity, err := openpgp.CheckArmoredDetachedSignature(entities, bytes.NewReader(message), strings.NewReader(signature), nil)
+	if err != nil {
+		return "", fmt.Errorf("untrusted signature: %w", err)
+	}
+
+	if identity := entity.PrimaryIdentity(); identity != nil {
+		return fmt.Sprintf("%s (pgp %s)", identity.Name, entity.PrimaryKey.KeyIdString()), nil
+	}
+	return fmt.Sprintf("pgp %s", entity.PrimaryKey.KeyIdString()), nil
+}
+
+type allowedSigner struct {
+	principals []string
+	// namespaces the key may sign in, any when empty.
+	namespaces []string
+	key        ssh.PublicKey
+}
+
+// allows reports whether the signer may sign as identity in namespace. Principals are patterns like in ssh_config,
+// e.g. "*@example.com", and a negated pattern excludes the identities it matches.
+func (s allowedSigner) allows(identity string, namespace string) bool {
+	if len(s.namespaces) > 0 && !matchPatterns(s.namespaces, namespace) {
+		return false
+	}
+	return matchPatterns(s.principals, identity)
+}
+
+func matchPatterns(patterns []string, value string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if !matchPattern(strings.TrimPrefix(pattern, "!"), value) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// matchPattern matches value against a pattern where '*' matches any characters and '?' a single one.
+func matchPattern(pattern string, value string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return regexp.MustCompile("^" + expr + "$").MatchString(value)
+}
+
+// parseAllowedSigners reads the ssh allowed signers format: "principal[,principal...] [options] keytype base64-key [comment]"
+// Of the options, namespaces="..." is honored. cert-authority and validity periods are not supported.
+func parseAllowedSigners(keyring string) ([]allowedSigner, error) {
+	signers := make([]allowedSigner, 0)
+
+	scanner := bufio.NewScanner(strings.NewReader(keyring))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid allowed signers line: %s", line)
+		}
+		principals := strings.Split(strings.Trim(fields[0], `"`), ",")
+
+		// options such as namespaces="git" sit between the principals and the key like in authorized_keys
+		key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[1:], " ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key for %s: %w", fields[0], err)
+		}
+
+		signer := allowedSigner{principals: principals, key: key}
+		for _, option := range options {
+			name, value, _ := strings.Cut(option, "=")
+			switch strings.ToLower(name) {
+			case "namespaces":
+				signer.namespaces = strings.Split(strings.Trim(value, `"`), ",")
+			case "cert-authority", "valid-after", "valid-before":
+				return nil, fmt.Errorf("option %s of %s is not supported", name, fields[0])
+			}
+		}
+		signers = append(signers, signer)
+	}
+	return signers, scanner.Err()
+}
+
+// sshSignature is the blob of an armored ssh signature, see PROTOCOL.sshsig in openssh.
+type sshSignature struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func verifySSHSignature(keyring string, signature string, identity string, message []byte) (string, error) {
+	signers, err := parseAllowedSigners(keyring)
+	if err != nil {
+		return "", fmt.Errorf("read keyring: %w", err)
+	}
+	if len(signers) == 0 {
+		return "", errors.New("signed with ssh, but the keyring contains no allowed signers")
+	}
+
+	block, _ := pem.Decode([]byte(signature))
+	if block == nil || block.Type != "SSH SIGNATURE" {
+		return "", errors.New("malformed ssh signature")
+	}
+	blob, ok := bytes.CutPrefix(block.Bytes, []byte(sshSignatureMagic))
+	if !ok {
+		return "", errors.New("malformed ssh signature")
+	}
+
+	var sig sshSignature
+	if err := ssh.Unmarshal(blob, &sig); err != nil {
+		return "", fmt.Errorf("malformed ssh signature: %w", err)
+	}
+	if sig.Version != sshSignatureVersion {
+		return "", fmt.Errorf("unsupported ssh signature version %d", sig.Version)
+	}
+	if sig.Namespace != sshSignatureNamespace {
+		return "", fmt.Errorf("ssh signature has namespace %q instead of %q", sig.Namespace, sshSignatureNamespace)
+	}
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported ssh signature hash %s", sig.HashAlgorithm)
+	}
+	h.Write(message)
+
+	pub, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("malformed ssh signature key: %w", err)
+	}
+	var sshSig ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &sshSig); err != nil {
+		return "", fmt.Errorf("malformed ssh signature: %w", err)
+	}
+
+	signed := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignedData{
+		Namespace:     sig.Namespace,
+		Reserved:      sig.Reserved,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          h.Sum(nil),
+	})...)
+	if err := pub.Verify(signed, &sshSig); err != nil {
+		return "", fmt.Errorf("invalid ssh signature: %w", err)
+	}
+
+	for _, signer := range signers {
+		if bytes.Equal(signer.key.Marshal(), pub.Marshal()) && signer.allows(identity, sig.Namespace) {
+			return fmt.Sprintf("%s (ssh %s)", identity, ssh.FingerprintSHA256(pub)), nil
+		}
+	}
+	return "", fmt.Errorf("untrusted signature: key %s is not an allowed signer for %s", ssh.FingerprintSHA256(pub), identity)
+}
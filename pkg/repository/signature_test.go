@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestVerifyPGPSignedCommit(t *testing.T) {
+	maintainer := newPGPEntity(t, "maintainer")
+	stranger := newPGPEntity(t, "stranger")
+
+	rep, err := git.PlainInit(t.TempDir(), false)
+	require.NoError(t, err)
+	writeAndCommit(t, rep, maintainer)
+
+	signer, tag, err := verifySignature(rep, "", armoredPublicKey(t, maintainer))
+	require.NoError(t, err)
+	require.Contains(t, signer, "maintainer <maintainer@example.com>")
+	require.Empty(t, tag)
+
+	_, _, err = verifySignature(rep, "", armoredPublicKey(t, stranger))
+	require.Error(t, err)
+
+	writeAndCommit(t, rep, nil)
+	_, _, err = verifySignature(rep, "", armoredPublicKey(t, maintainer))
+	require.ErrorIs(t, err, errUnsigned)
+}
+
+func TestVerifyPGPSignedTag(t *testing.T) {
+	maintainer := newPGPEntity(t, "maintainer")
+
+	rep, err := git.PlainInit(t.TempDir(), false)
+	require.NoError(t, err)
+	hash := writeAndCommit(t, rep, nil)
+
+	_, err = rep.CreateTag("v1.0.0", hash, &git.CreateTagOptions{
+		Tagger:  testSignature(),
+		Message: "release v1.0.0",
+		SignKey: maintainer,
+	})
+	require.NoError(t, err)
+
+	signer, tag, err := verifySignature(rep, "v1.0.0", armoredPublicKey(t, maintainer))
+	require.NoError(t, err)
+	require.Contains(t, signer, "maintainer")
+	require.Equal(t, "v1.0.0", tag)
+
+	// the tag no longer covers a newer checked out commit
+	writeAndCommit(t, rep, nil)
+	_, _, err = verifySignature(rep, "v1.0.0", armoredPublicKey(t, maintainer))
+	require.ErrorContains(t, err, "tag v1.0.0 points to "+hash.String())
+}
+
+func TestVerifySSHSignedCommit(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	rep, err := git.PlainInit(t.TempDir(), false)
+	require.NoError(t, err)
+	parent := writeAndCommit(t, rep, nil)
+	parentCommit, err := rep.CommitObject(parent)
+	require.NoError(t, err)
+
+	commit := &object.Commit{
+		Author:       *testSignature(),
+		Committer:    *testSignature(),
+		Message:      "signed with ssh",
+		TreeHash:     parentCommit.TreeHash,
+		ParentHashes: []plumbing.Hash{parent},
+	}
+	unsigned := &plumbing.MemoryObject{}
+	require.NoError(t, commit.EncodeWithoutSignature(unsigned))
+	commit.PGPSignature = sshSign(t, signer, objectContent(t, unsigned))
+
+	obj := rep.Storer.NewEncodedObject()
+	require.NoError(t, commit.Encode(obj))
+	hash, err := rep.Storer.SetEncodedObject(obj)
+	require.NoError(t, err)
+	require.NoError(t, rep.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, hash)))
+
+	allowedSigners := "# maintainers\nmaintainer@example.com,*@example.com namespaces=\"file,git\" " + string(ssh.MarshalAuthorizedKey(sshPub))
+	actual, _, err := verifySignature(rep, "", allowedSigners)
+	require.NoError(t, err)
+	require.Equal(t, "protodep@example.com (ssh "+ssh.FingerprintSHA256(sshPub)+")", actual)
+
+	// the key is only trusted for its principals and namespaces
+	_, _, err = verifySignature(rep, "", "maintainer@example.com "+string(ssh.MarshalAuthorizedKey(sshPub)))
+	require.ErrorContains(t, err, "not an allowed signer for protodep@example.com")
+	_, _, err = verifySignature(rep, "", "*@example.com,!protodep@example.com "+string(ssh.MarshalAuthorizedKey(sshPub)))
+	require.Error(t, err)
+	_, _, err = verifySignature(rep, "", "*@example.com namespaces=\"file\" "+string(ssh.MarshalAuthorizedKey(sshPub)))
+	require.Error(t, err)
+
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherSSHPub, err := ssh.NewPublicKey(otherPub)
+	require.NoError(t, err)
+	_, _, err = verifySignature(rep, "", "protodep@example.com "+string(ssh.MarshalAuthorizedKey(otherSSHPub)))
+	require.Error(t, err)
+}
+
+func newPGPEntity(t *testing.T, name string) *openpgp.Entity {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	require.NoError(t, err)
+	return entity
+}
+
+func armoredPublicKey(t *testing.T, entity *openpgp.Entity) string {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+	return buf.String()
+}
+
+func writeAndCommit(t *testing.T, rep *git.Repository, signKey *openpgp.Entity) plumbing.Hash {
+	wt, err := rep.Worktree()
+	require.NoError(t, err)
+
+	f, err := wt.Filesystem.Create("api.proto")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("syntax = \"proto3\";\n// " + time.Now().String() + "\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = wt.Add("api.proto")
+	require.NoError(t, err)
+	hash, err := wt.Commit("update", &git.CommitOptions{
+		Author:  testSignature(),
+		SignKey: signKey,
+	})
+	require.NoError(t, err)
+	return hash
+}
+
+func testSignature() *object.Signature {
+	return &object.Signature{Name: "protodep", Email: "protodep@example.com", When: time.Now()}
+}
+
+func objectContent(t *testing.T, obj plumbing.EncodedObject) []byte {
+	r, err := obj.Reader()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(r)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+// sshSign creates an armored signature like `ssh-keygen -Y sign -n git` does.
+func sshSign(t *testing.T, signer ssh.Signer, message []byte) string {
+	h := sha512.Sum512(message)
+	signed := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignedData{
+		Namespace:     sshSignatureNamespace,
+		HashAlgorithm: "sha512",
+		Hash:          h[:],
+	})...)
+
+	sig, err := signer.Sign(rand.Reader, signed)
+	require.NoError(t, err)
+
+	blob := append([]byte(sshSignatureMagic), ssh.Marshal(sshSignature{
+		Version:       sshSignatureVersion,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     sshSignatureNamespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(sig),
+	})...)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "SSH SIGNATURE", Bytes: blob}))
+}
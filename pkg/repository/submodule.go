@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+
+	"github.com/stormcat24/protodep/pkg/auth"
+	"github.com/stormcat24/protodep/pkg/logger"
+)
+
+// updateSubmodules initializes and checks out the submodules of rep, recursively up to depth levels.
+// Every submodule is fetched through the AuthProvider selected for its own repository.
+func (r *github) updateSubmodules(rep *git.Repository, parent string, depth git.SubmoduleRescursivity) error {
+	if depth == git.NoRecurseSubmodules {
+		return nil
+	}
+
+	wt, err := rep.Worktree()
+	if err != nil {
+		return fmt.Errorf("get worktree: %w", err)
+	}
+
+	submodules, err := wt.Submodules()
+	if err != nil {
+		return fmt.Errorf("read submodules of %s: %w", parent, err)
+	}
+
+	for _, sm := range submodules {
+		cfg := sm.Config()
+
+		reponame, err := submoduleRepository(parent, cfg.URL)
+		if err != nil {
+			return fmt.Errorf("submodule %s of %s: %w", cfg.Name, parent, err)
+		}
+
+		provider, err := r.submoduleAuth(reponame)
+		if err != nil {
+			return fmt.Errorf("submodule %s of %s: %w", cfg.Name, parent, err)
+		}
+		cfg.URL = provider.GetRepositoryURL(reponame)
+		am, err := auth.URLAuthMethod(provider, reponame, cfg.URL)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("updating submodule %s of %s from %s", cfg.Path, parent, reponame)
+
+		if err := sm.Init(); err != nil && err != git.ErrSubmoduleAlreadyInitialized {
+			return fmt.Errorf("init submodule %s: %w", cfg.Name, err)
+		}
+
+		subrep, err := sm.Repository()
+		if err != nil {
+			return fmt.Errorf("open submodule %s: %w", cfg.Name, err)
+		}
+		if err := r.syncRemote(subrep, reponame, cfg.URL); err != nil {
+			return err
+		}
+
+		if err := sm.Update(&git.SubmoduleUpdateOptions{Auth: am}); err != nil {
+			return fmt.Errorf("update submodule %s: %w", cfg.Name, err)
+		}
+
+		if err := r.updateSubmodules(subrep, reponame, depth-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warnUninitializedSubmodules tells about submodules which are left empty because the dependency didn't opt in.
+func (r *github) warnUninitializedSubmodules(wt *git.Worktree) {
+	submodules, err := wt.Submodules()
+	if err != nil {
+		return
+	}
+	for _, sm := range submodules {
+		logger.Warn("%s contains submodule %s which is not vendored, set 'submodules = true' to include it", r.dep.Repository(), sm.Config().Path)
+	}
+}
+
+// submoduleRepository converts the url of a submodule to a repository name like github.com/org/repo.
+// Relative urls are resolved against the parent repository name.
+func submoduleRepository(parent string, rawURL string) (string, error) {
+	var reponame string
+
+	if strings.HasPrefix(rawURL, "./") || strings.HasPrefix(rawURL, "../") {
+		reponame = strings.TrimSuffix(strings.TrimSuffix(path.Join(parent, rawURL), "/"), ".git")
+	} else {
+		var err error
+		reponame, _, err = auth.URLRepository(rawURL)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if strings.Count(reponame, "/") < 2 || strings.HasPrefix(reponame, "..") {
+		return "", fmt.Errorf("can't resolve repository of url %s", rawURL)
+	}
+	return reponame, nil
+}
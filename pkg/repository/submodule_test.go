@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stormcat24/protodep/pkg/auth"
+	"github.com/stormcat24/protodep/pkg/config"
+)
+
+func TestOpenSubmodules(t *testing.T) {
+	dir := t.TempDir()
+	provider := installSubmoduleUpstreams(t)
+
+	opened, err := NewGit(dir, config.ProtoDepDependency{Target: "example.com/org/api", Submodules: true}, provider).Open()
+	require.NoError(t, err)
+
+	// submodules of submodules are initialized too
+	repopath := filepath.Join(dir, "example.com/org/api")
+	require.FileExists(t, filepath.Join(repopath, "common", "common.proto"))
+	require.FileExists(t, filepath.Join(repopath, "common", "nested", "nested.proto"))
+
+	// the submodule is fetched from the url of the provider, not the one in .gitmodules
+	wt, err := opened.Repository.Worktree()
+	require.NoError(t, err)
+	sm, err := wt.Submodule("common")
+	require.NoError(t, err)
+	subrep, err := sm.Repository()
+	require.NoError(t, err)
+	remote, err := subrep.Remote(git.DefaultRemoteName)
+	require.NoError(t, err)
+	require.Equal(t, []string{"file:///example.com/org/common"}, remote.Config().URLs)
+}
+
+func TestUpdateSubmodulesDepth(t *testing.T) {
+	dir := t.TempDir()
+	provider := installSubmoduleUpstreams(t)
+
+	// without opting in, submodules are left empty
+	r := NewGit(dir, config.ProtoDepDependency{Target: "example.com/org/api"}, provider).(*github)
+	opened, err := r.Open()
+	require.NoError(t, err)
+	repopath := filepath.Join(dir, "example.com/org/api")
+	require.NoFileExists(t, filepath.Join(repopath, "common", "common.proto"))
+
+	require.NoError(t, r.updateSubmodules(opened.Repository, "example.com/org/api", 1))
+	require.FileExists(t, filepath.Join(repopath, "common", "common.proto"))
+	require.NoFileExists(t, filepath.Join(repopath, "common", "nested", "nested.proto"))
+}
+
+// installSubmoduleUpstreams serves example.com/org/api, which has example.com/org/common as submodule,
+// which itself has example.com/org/nested as submodule.
+func installSubmoduleUpstreams(t *testing.T) auth.AuthProvider {
+	nested := initUpstream(t, "main")
+	nestedHead := commitFile(t, nested, "nested.proto", "syntax = \"proto3\";\npackage nested;\n")
+
+	common := initUpstream(t, "main")
+	commitFile(t, common, "common.proto", "syntax = \"proto3\";\npackage common;\n")
+	commonHead := commitSubmodule(t, common, "nested", "../nested.git", nestedHead)
+
+	api := initUpstream(t, "main")
+	commitSubmodule(t, api, "common", "https://example.com/org/common.git", commonHead)
+
+	loader := server.MapLoader{}
+	for reponame, rep := range map[string]*git.Repository{
+		"example.com/org/api":    api,
+		"example.com/org/common": common,
+		"example.com/org/nested": nested,
+	} {
+		ep, err := transport.NewEndpoint("file:///" + reponame)
+		require.NoError(t, err)
+		loader[ep.String()] = rep.Storer
+	}
+	client.InstallProtocol("file", server.NewClient(loader))
+
+	c := gomock.NewController(t)
+	provider := auth.NewMockAuthProvider(c)
+	provider.EXPECT().AuthMethod().Return(nil, nil).AnyTimes()
+	provider.EXPECT().GetRepositoryURL(gomock.Any()).DoAndReturn(func(reponame string) string {
+		return "file:///" + reponame
+	}).AnyTimes()
+	return provider
+}
+
+// commitSubmodule records hash as the submodule at path, go-git can't add a gitlink from the worktree.
+func commitSubmodule(t *testing.T, rep *git.Repository, path string, url string, hash plumbing.Hash) plumbing.Hash {
+	wt, err := rep.Worktree()
+	require.NoError(t, err)
+
+	gitmodules := fmt.Sprintf("[submodule %q]\n\tpath = %s\n\turl = %s\n", path, path, url)
+	require.NoError(t, os.WriteFile(filepath.Join(wt.Filesystem.Root(), ".gitmodules"), []byte(gitmodules), 0644))
+	_, err = wt.Add(".gitmodules")
+	require.NoError(t, err)
+
+	idx, err := rep.Storer.Index()
+	require.NoError(t, err)
+	entry := idx.Add(path)
+	entry.Mode = filemode.Submodule
+	entry.Hash = hash
+	require.NoError(t, rep.Storer.SetIndex(idx))
+
+	commit, err := wt.Commit("add submodule "+path, &git.CommitOptions{
+		Author: &object.Signature{Name: "protodep", Email: "protodep@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	return commit
+}
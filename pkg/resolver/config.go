@@ -1,5 +1,7 @@
 package resolver
 
+import "github.com/stormcat24/protodep/pkg/config"
+
 type Config struct {
 	// UseHttps will force https on each proto dependencies fetch.
 	UseHttps bool
@@ -7,6 +9,9 @@ type Config struct {
 	// HomeDir is the home directory, used as root to find ssh identity files.
 	HomeDir string
 
+	// CacheDir is the directory where dependency repositories are cloned and cached. Optional, it is computed like {home}/.cache/protodep
+	CacheDir string
+
 	// TargetDir is the dependencies directory where protodep.toml files are located.
 	TargetDir string
 
@@ -19,9 +24,26 @@ type Config struct {
 	// BasicAuthPassword is used if `https` mode is enable. Optional, only if dependency repository needs authentication.
 	BasicAuthPassword string
 
+	// Sessions are the credentials stored by `protodep login`, keyed by host. Dependencies of these hosts are fetched via https,
+	// with the session used when neither flags, environment variables nor Credentials give credentials for the host.
+	Sessions map[string]config.Credential
+
 	// IdentityFile is used if `ssh` mode is enable. Optional, it is computed like {home}/.ssh/
 	IdentityFile string
 
 	// IdentityPassword is used if `ssh` mode is enable. Optional, only if identity file needs a passphrase.
 	IdentityPassword string
+
+	// CABundle is a PEM file of certificates trusted in addition to the system ones for https. Optional.
+	CABundle string
+
+	// InsecureSkipTLS disables the verification of https server certificates. Never use it outside of debugging.
+	InsecureSkipTLS bool
+
+	// Credentials are keyed by host or host and path prefix, e.g. "gitlab.example.com" or "github.com/my-org".
+	// They are used for matching dependencies when no basic auth or identity file flag is given.
+	Credentials map[string]config.Credential
+
+	// URLRewrites are the user-wide url rewrite rules, applied after the ones of protodep.toml.
+	URLRewrites []config.URLRewrite
 }
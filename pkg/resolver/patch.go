@@ -0,0 +1,696 @@
+package resolver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"golang.org/x/mod/modfile"
+
+	"github.com/stormcat24/protodep/pkg/config"
+	"github.com/stormcat24/protodep/pkg/protoparse"
+)
+
+// patchIndent indents the annotation of declarations whose body gives no indentation to follow.
+const patchIndent = "    "
+
+// patcher applies smart-patch to the vendored files.
+type patcher struct {
+	// annotation, enumAnnotation and serviceAnnotation are the options set on messages, enums and services, the last
+	// two are optional.
+	annotation        string
+	enumAnnotation    string
+	serviceAnnotation string
+	// packages are the templates of the patched packages by dependency target, nil for the default package.
+	packages map[string]*template.Template
+	// options are the templates of the language options to rewrite, by option name.
+	options  map[string]*template.Template
+	goModule string
+	// annotationImports are the import paths of the generated annotation protos by option, empty when they aren't
+	// generated.
+	annotationImports map[string]string
+	// renames are the patched names of the types and extensions of the vendored files, by original full name.
+	renames map[string][]patchedSymbol
+	// imports are the vendored paths of the vendored files, by the paths upstream files import them with.
+	imports map[string][]patchedSymbol
+	// before and after resolve references before and after patching.
+	before symbolTable
+	after  symbolTable
+	// files are the packages of the vendored files declaring one, before and after patching.
+	files []patchedFile
+}
+
+// vendoredFile is a proto file of a dependency, patched once all the vendored files are known.
+type vendoredFile struct {
+	dep    config.ProtoDepDependency
+	source string
+	// relativeDest is the path of the file in the dependency target, path is the one it is vendored at in proto_outdir.
+	relativeDest string
+	path         string
+	outpath      string
+	content      []byte
+}
+
+// upstreamImports returns the paths upstream files may import f with: relative to the dependency target, relative to
+// the repository root, or prefixed by the target like Go import paths.
+func (f vendoredFile) upstreamImports() []string {
+	relative := strings.TrimPrefix(filepath.ToSlash(f.relativeDest), "/")
+	paths := []string{relative, filepath.ToSlash(filepath.Join(f.dep.Target, relative))}
+	if dir := f.dep.Directory(); dir != "." {
+		paths = append(paths, filepath.ToSlash(filepath.Join(dir, relative)))
+	}
+	return paths
+}
+
+// patchedFile is the package of a vendored file once patched, with the one declared upstream.
+type patchedFile struct {
+	Path            string `json:"path"`
+	Package         string `json:"package"`
+	OriginalPackage string `json:"original_package"`
+}
+
+// patchMapping maps the names of the vendored files once patched to their upstream names, written to patch_mapping.
+type patchMapping struct {
+	Files   []patchedFile     `json:"files"`
+	Symbols map[string]string `json:"symbols"`
+}
+
+// mapping returns the patched names of the indexed files, with their upstream names.
+func (p *patcher) mapping() patchMapping {
+	mapping := patchMapping{Files: p.files, Symbols: make(map[string]string, len(p.renames))}
+	if mapping.Files == nil {
+		mapping.Files = make([]patchedFile, 0)
+	}
+	for original, renames := range p.renames {
+		for _, renamed := range renames {
+			mapping.Symbols[renamed.name] = original
+		}
+	}
+	return mapping
+}
+
+// patchedSymbol is the patched name of a type, an extension or a file, with the target of the dependency declaring it.
+type patchedSymbol struct {
+	name   string
+	target string
+}
+
+func newPatcher(protodep *config.ProtoDep, targetDir string) (*patcher, error) {
+	p := &patcher{
+		annotation:        protodep.PatchAnnotation,
+		enumAnnotation:    protodep.PatchEnumAnnotation,
+		serviceAnnotation: protodep.PatchServiceAnnotation,
+		packages:          make(map[string]*template.Template, len(protodep.Dependencies)),
+		options:           make(map[string]*template.Template, len(protodep.PatchOptions)),
+		annotationImports: make(map[string]string),
+	}
+	for _, dep := range protodep.Dependencies {
+		text := dep.PatchPackage
+		if text == "" {
+			text = protodep.PatchPackage
+		}
+		if text == "" {
+			continue
+		}
+		tmpl, err := config.ParsePatchPackage(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dep.Target, err)
+		}
+		p.packages[dep.Target] = tmpl
+	}
+	for name, text := range protodep.PatchOptions {
+		tmpl, err := config.ParsePatchOption(name, text)
+		if err != nil {
+			return nil, err
+		}
+		p.options[name] = tmpl
+	}
+
+	if protodep.PatchAnnotationField != 0 {
+		for _, annotation := range protodep.PatchAnnotations() {
+			file, _ := annotationProto(annotation, protodep.PatchAnnotationField)
+			p.annotationImports[annotation.Option] = filepath.ToSlash(filepath.Join(protodep.ProtoOutdir, file))
+		}
+	}
+
+	for name, tmpl := range p.options {
+		if !config.UsesField(tmpl, "GoModule") {
+			continue
+		}
+		gomod := filepath.Join(targetDir, "go.mod")
+		content, err := os.ReadFile(gomod)
+		if err != nil {
+			return nil, fmt.Errorf("patch_options %s uses .GoModule: %w", name, err)
+		}
+		p.goModule = modfile.ModulePath(content)
+		if p.goModule == "" {
+			return nil, fmt.Errorf("patch_options %s uses .GoModule, but %s declares no module", name, gomod)
+		}
+		break
+	}
+	return p, nil
+}
+
+// patch moves a vendored proto of dep to the package of its location, or the one of its patch_package template,
+// and records the original name of each message, and of enums and services when configured, with the annotation options.
+// Only the rewritten names and values change, comments and formatting are kept. Files of dependencies with
+// patch = false, and of the unpatched packages like google.protobuf, are returned as they are.
+func (p *patcher) patch(content []byte, path string, dep config.ProtoDepDependency) ([]byte, error) {
+	if len(content) == 0 || !dep.PatchEnabled() {
+		return content, nil
+	}
+	file, err := protoparse.Parse(content)
+	if err != nil {
+		return nil, err
+	}
+	if file.Package != nil && config.IsUnpatchedPackage(file.Package.Name) {
+		return content, nil
+	}
+
+	dirs := strings.Split(path, "/")
+	originalPackage := ""
+	if file.Package != nil {
+		originalPackage = file.Package.Name
+	}
+	relativePackage, err := p.packageOf(file, path, dep)
+	if err != nil {
+		return nil, err
+	}
+
+	edits := make([]protoparse.Edit, 0)
+	if file.Package != nil {
+		edits = append(edits, protoparse.Edit{Span: file.Package.NameSpan, Text: relativePackage})
+	}
+
+	if _, ok := p.options["java_package"]; !ok {
+		for _, opt := range file.Options {
+			if opt.Name == "java_package" {
+				javaPackage := relativePackage
+				if !strings.HasPrefix(javaPackage, "com.") {
+					javaPackage = "com." + javaPackage
+				}
+				edits = append(edits, protoparse.Edit{Span: opt.ValueSpan, Text: protoparse.Quote(javaPackage)})
+			}
+		}
+	}
+
+	optionEdits, err := p.patchOptions(file, config.PatchOptionData{
+		Package:         relativePackage,
+		OriginalPackage: originalPackage,
+		Dir:             strings.Join(dirs[0:len(dirs)-1], "/"),
+		File:            strings.TrimSuffix(dirs[len(dirs)-1], ".proto"),
+		GoModule:        p.goModule,
+	})
+	if err != nil {
+		return nil, err
+	}
+	edits = append(edits, optionEdits...)
+
+	imported := make(map[string]bool, len(file.Imports))
+	for _, imp := range file.Imports {
+		local := imp.Path
+		if vendored, ok := p.imports[imp.Path]; ok {
+			local = pickSymbol(vendored, dep.Target)
+		}
+		if imported[local] {
+			// an earlier import resolved to the same vendored file, protoc rejects importing a file twice
+			edits = append(edits, protoparse.Edit{Span: removalSpan(file.Content, imp.Span)})
+			continue
+		}
+		imported[local] = true
+		if local != imp.Path {
+			edits = append(edits, protoparse.Edit{Span: imp.PathSpan, Text: protoparse.Quote(local)})
+		}
+	}
+
+	if file.Package != nil {
+		edits = append(edits, p.patchReferences(file, originalPackage, relativePackage, dep.Target)...)
+	}
+
+	if originalPackage != "" {
+		used := make(map[string]bool)
+		file.Walk(func(node protoparse.Node, parents []protoparse.Node) bool {
+			name := qualify(originalPackage, protoparse.Scope(parents))
+			switch n := node.(type) {
+			case *protoparse.Message:
+				edits = append(edits, annotate(file, n.Decls, n.Body, n.Span, len(parents)+1, p.annotation, qualify(name, n.Name))...)
+				used[p.annotation] = true
+			case *protoparse.Enum:
+				if p.enumAnnotation != "" {
+					edits = append(edits, annotate(file, n.Decls, n.Body, n.Span, len(parents)+1, p.enumAnnotation, qualify(name, n.Name))...)
+					used[p.enumAnnotation] = true
+				}
+			case *protoparse.Service:
+				if p.serviceAnnotation != "" {
+					edits = append(edits, annotate(file, n.Decls, n.Body, n.Span, len(parents)+1, p.serviceAnnotation, qualify(name, n.Name))...)
+					used[p.serviceAnnotation] = true
+				}
+			}
+			return true
+		})
+
+		imports := make([]string, 0, len(used))
+		for _, annotation := range []string{p.annotation, p.enumAnnotation, p.serviceAnnotation} {
+			if path := p.annotationImports[annotation]; used[annotation] && path != "" && !imported[path] {
+				imports = append(imports, path)
+			}
+		}
+		edits = append(edits, importAnnotations(file, imports)...)
+	}
+
+	return protoparse.Apply(content, edits)
+}
+
+// packageOf returns the package of a vendored file once patched, the one of its location or of its patch_package template.
+func (p *patcher) packageOf(file *protoparse.File, path string, dep config.ProtoDepDependency) (string, error) {
+	dirs := strings.Split(path, "/")
+	relativePackage := config.SanitizePackage(strings.Join(dirs[0:len(dirs)-1], "."))
+	tmpl, ok := p.packages[dep.Target]
+	if !ok {
+		return relativePackage, nil
+	}
+	originalPackage := ""
+	if file.Package != nil {
+		originalPackage = file.Package.Name
+	}
+	return config.ExecutePatchPackage(tmpl, config.PatchPackageData{
+		Path:            relativePackage,
+		OriginalPackage: originalPackage,
+		Dir:             strings.Join(dirs[0:len(dirs)-1], "/"),
+		File:            strings.TrimSuffix(dirs[len(dirs)-1], ".proto"),
+		DependencyPath:  dep.Path,
+	})
+}
+
+// index records the types and extensions declared by all the vendored files, before and after patching, so patch
+// rewrites the references between them.
+func (p *patcher) index(files []vendoredFile) error {
+	p.renames = make(map[string][]patchedSymbol)
+	p.files = make([]patchedFile, 0)
+	p.imports = make(map[string][]patchedSymbol)
+	p.before = newSymbolTable()
+	p.after = newSymbolTable()
+	for _, f := range files {
+		// files left as they are keep their upstream imports and names
+		if !f.dep.PatchEnabled() {
+			continue
+		}
+		var file *protoparse.File
+		var err error
+		if len(f.content) > 0 {
+			file, err = protoparse.Parse(f.content)
+			if err != nil {
+				return fmt.Errorf("patch %s: %w", f.source, err)
+			}
+			if file.Package != nil && config.IsUnpatchedPackage(file.Package.Name) {
+				continue
+			}
+		}
+		for _, upstream := range f.upstreamImports() {
+			p.imports[upstream] = append(p.imports[upstream], patchedSymbol{name: f.path, target: f.dep.Target})
+		}
+		if file == nil {
+			continue
+		}
+
+		originalPackage, patchedPackage := "", ""
+		if file.Package != nil {
+			originalPackage = file.Package.Name
+			patchedPackage, err = p.packageOf(file, f.path, f.dep)
+			if err != nil {
+				return fmt.Errorf("patch %s: %w", f.source, err)
+			}
+		}
+		p.rename(file, originalPackage, patchedPackage, f.dep.Target)
+		if file.Package != nil {
+			p.files = append(p.files, patchedFile{Path: f.path, Package: patchedPackage, OriginalPackage: originalPackage})
+		}
+	}
+	return nil
+}
+
+// rename records the declarations of file moving from package from to package to.
+func (p *patcher) rename(file *protoparse.File, from string, to string, target string) {
+	p.before.addPackage(from)
+	p.after.addPackage(to)
+	for _, name := range declaredNames(file) {
+		before, after := qualify(from, name), qualify(to, name)
+		p.before.symbols[before] = true
+		p.after.symbols[after] = true
+		p.renames[before] = append(p.renames[before], patchedSymbol{name: after, target: target})
+	}
+}
+
+// declaredNames returns the names of the messages, enums, services and extensions of file, relative to its package.
+func declaredNames(file *protoparse.File) []string {
+	names := make([]string, 0)
+	file.Walk(func(node protoparse.Node, parents []protoparse.Node) bool {
+		name := ""
+		switch n := node.(type) {
+		case *protoparse.Message:
+			name = n.Name
+		case *protoparse.Enum:
+			name = n.Name
+		case *protoparse.Service:
+			name = n.Name
+		case *protoparse.Field:
+			if len(parents) > 0 {
+				if _, ok := parents[len(parents)-1].(*protoparse.Extend); ok {
+					name = n.Name
+				}
+			}
+		}
+		if name != "" {
+			names = append(names, qualify(protoparse.Scope(parents), name))
+		}
+		return true
+	})
+	return names
+}
+
+// patchReferences rewrites the references to types and extensions of vendored files that are renamed, for file moving
+// from package from to package to: field types, rpc signatures, extended types, extensions in option names and in
+// message literal values. References to other types, e.g. google.protobuf.Any, are made fully-qualified when a patched
+// package or type now shadows their first component, like proto.googleapis.google does for google.protobuf.
+func (p *patcher) patchReferences(file *protoparse.File, from string, to string, target string) []protoparse.Edit {
+	if p.renames == nil {
+		return nil
+	}
+
+	edits := make([]protoparse.Edit, 0)
+	rewrite := func(ref protoparse.TypeRef, scope string) {
+		beforeScope, afterScope := qualify(from, scope), qualify(to, scope)
+		before := p.before.resolve(ref.Name, beforeScope)
+		if before == "" {
+			if strings.HasPrefix(ref.Name, ".") {
+				return
+			}
+			// without a vendored package or type in the way, the reference is relative to the root
+			original, bound := p.before.bind(ref.Name, beforeScope), p.after.bind(ref.Name, afterScope)
+			if original == "" {
+				original = ref.Name
+			}
+			if bound == "" {
+				bound = ref.Name
+			}
+			if bound != original {
+				edits = append(edits, protoparse.Edit{Span: ref.Span, Text: "." + original})
+			}
+			return
+		}
+		after := pickSymbol(p.renames[before], target)
+		if p.after.resolve(ref.Name, afterScope) == after {
+			return
+		}
+		text := "." + after
+		if !strings.HasPrefix(ref.Name, ".") && p.after.resolve(after, afterScope) == after {
+			text = after
+		}
+		edits = append(edits, protoparse.Edit{Span: ref.Span, Text: text})
+	}
+	rewriteOptions := func(opts []*protoparse.Option, scope string) {
+		for _, opt := range opts {
+			// annotations are rewritten by annotate
+			if isAnnotation(opt, p.annotation) || isAnnotation(opt, p.enumAnnotation) || isAnnotation(opt, p.serviceAnnotation) {
+				continue
+			}
+			for _, ref := range opt.Refs {
+				rewrite(ref, scope)
+			}
+		}
+	}
+
+	file.Walk(func(node protoparse.Node, parents []protoparse.Node) bool {
+		scope := protoparse.Scope(parents)
+		switch n := node.(type) {
+		case *protoparse.Field:
+			if n.Group == nil {
+				rewrite(n.Type, scope)
+			}
+			rewriteOptions(n.Options, scope)
+		case *protoparse.EnumValue:
+			rewriteOptions(n.Options, scope)
+		case *protoparse.RPC:
+			rewrite(n.Input, scope)
+			rewrite(n.Output, scope)
+		case *protoparse.Extend:
+			rewrite(n.Type, scope)
+		case *protoparse.Option:
+			rewriteOptions([]*protoparse.Option{n}, scope)
+		}
+		return true
+	})
+	return edits
+}
+
+// pickSymbol returns the patched name of a symbol, the one vendored by the dependency target when several vendor it.
+func pickSymbol(candidates []patchedSymbol, target string) string {
+	for _, candidate := range candidates {
+		if candidate.target == target {
+			return candidate.name
+		}
+	}
+	return candidates[0].name
+}
+
+// symbolTable holds the full names of types, extensions and packages, to resolve references the way protoc does.
+type symbolTable struct {
+	symbols  map[string]bool
+	packages map[string]bool
+}
+
+func newSymbolTable() symbolTable {
+	return symbolTable{symbols: make(map[string]bool), packages: make(map[string]bool)}
+}
+
+// addPackage records pkg and its parent packages.
+func (t symbolTable) addPackage(pkg string) {
+	for pkg != "" {
+		t.packages[pkg] = true
+		i := strings.LastIndex(pkg, ".")
+		if i < 0 {
+			break
+		}
+		pkg = pkg[:i]
+	}
+}
+
+// resolve returns the full name ref refers to from scope, or "" when it isn't in the table.
+func (t symbolTable) resolve(ref string, scope string) string {
+	if name := t.bind(ref, scope); t.symbols[name] {
+		return name
+	}
+	return ""
+}
+
+// bind returns the full name ref refers to from scope once its first component is found in the table, whether the rest
+// is in the table or not, or "" when the first component isn't. Like protoc, the first component of ref is looked up
+// from the innermost scope outwards, the rest inside it.
+func (t symbolTable) bind(ref string, scope string) string {
+	if strings.HasPrefix(ref, ".") {
+		return ref[1:]
+	}
+	first := ref
+	if i := strings.Index(ref, "."); i >= 0 {
+		first = ref[:i]
+	}
+	for {
+		if candidate := qualify(scope, first); t.symbols[candidate] || t.packages[candidate] {
+			return qualify(scope, ref)
+		}
+		if scope == "" {
+			return ""
+		}
+		if i := strings.LastIndex(scope, "."); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+// qualify joins a scope and a name, either may be empty.
+func qualify(scope string, name string) string {
+	if scope == "" {
+		return name
+	}
+	if name == "" {
+		return scope
+	}
+	return scope + "." + name
+}
+
+// patchOptions sets the language options of patch_options. Options missing from the file are added after the last file
+// option, or after the package. An empty value removes the option.
+func (p *patcher) patchOptions(file *protoparse.File, data config.PatchOptionData) ([]protoparse.Edit, error) {
+	if len(p.options) == 0 {
+		return nil, nil
+	}
+
+	existing := make(map[string]*protoparse.Option)
+	values := make(map[string]string)
+	for _, opt := range file.Options {
+		existing[opt.Name] = opt
+		values[opt.Name] = opt.Value
+	}
+	rendered, err := config.ExecutePatchOptions(p.options, data, values)
+	if err != nil {
+		return nil, err
+	}
+
+	edits := make([]protoparse.Edit, 0)
+	missing := make([]string, 0)
+	removed := make(map[protoparse.Node]bool)
+	for _, name := range config.PatchableOptions {
+		value, ok := rendered[name]
+		if !ok {
+			continue
+		}
+		opt, exists := existing[name]
+		switch {
+		case exists && value == "":
+			edits = append(edits, protoparse.Edit{Span: protoparse.LineSpan(file.Content, opt.Span)})
+			removed[opt] = true
+		case exists:
+			edits = append(edits, protoparse.Edit{Span: opt.ValueSpan, Text: protoparse.Quote(value)})
+		case value != "":
+			missing = append(missing, fmt.Sprintf("option %s = %s;", name, protoparse.Quote(value)))
+		}
+	}
+	if len(missing) == 0 {
+		return edits, nil
+	}
+
+	var after protoparse.Node
+	for _, decl := range file.Decls {
+		if removed[decl] {
+			continue
+		}
+		switch decl.(type) {
+		case *protoparse.Option, *protoparse.Package:
+			after = decl
+		case *protoparse.Statement:
+			if after == nil {
+				after = decl
+			}
+		}
+	}
+	return append(edits, insertAfter(file, after, missing)), nil
+}
+
+// insertAfter returns the edit adding lines after node, on its line when code follows it, or at the start of the file
+// without node.
+func insertAfter(file *protoparse.File, after protoparse.Node, lines []string) protoparse.Edit {
+	lineBreak := protoparse.LineBreak(file.Content)
+	if after == nil {
+		return protoparse.Edit{Text: strings.Join(lines, lineBreak) + lineBreak}
+	}
+	at, endOfLine := file.LineEnd(after.Pos().End)
+	if !endOfLine {
+		return protoparse.Edit{Span: protoparse.Span{Start: at, End: at}, Text: " " + strings.Join(lines, " ")}
+	}
+	return protoparse.Edit{Span: protoparse.Span{Start: at, End: at}, Text: lineBreak + strings.Join(lines, lineBreak)}
+}
+
+// annotationProto returns the path in proto_outdir and the content of the proto defining annotation as a string option
+// of its extendee, e.g. api/derived_from.proto for api.derived_from.
+func annotationProto(annotation config.Annotation, number int) (string, []byte) {
+	components := strings.Split(strings.TrimPrefix(annotation.Option, "."), ".")
+	name := components[len(components)-1]
+	pkg := strings.Join(components[:len(components)-1], ".")
+
+	var b strings.Builder
+	b.WriteString("// Code generated by protodep. DO NOT EDIT.\n")
+	fmt.Fprintf(&b, "// The annotation smart-patch sets on vendored %s, with their original full name.\n\n", annotation.Kind)
+	b.WriteString("syntax = \"proto3\";\n\n")
+	if pkg != "" {
+		fmt.Fprintf(&b, "package %s;\n\n", pkg)
+	}
+	b.WriteString("import \"google/protobuf/descriptor.proto\";\n\n")
+	fmt.Fprintf(&b, "extend %s {\n%sstring %s = %d;\n}\n", annotation.Extendee, patchIndent, name, number)
+	return strings.Join(components, "/") + ".proto", []byte(b.String())
+}
+
+// importAnnotations imports the generated annotation protos after the last import, or after the package, unless the
+// file already imports them.
+func importAnnotations(file *protoparse.File, paths []string) []protoparse.Edit {
+	missing := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		missing[path] = true
+	}
+	var after protoparse.Node
+	for _, decl := range file.Decls {
+		switch n := decl.(type) {
+		case *protoparse.Import:
+			delete(missing, n.Path)
+			after = decl
+		case *protoparse.Package:
+			if _, ok := after.(*protoparse.Import); !ok {
+				after = decl
+			}
+		case *protoparse.Statement:
+			if after == nil {
+				after = decl
+			}
+		}
+	}
+	lines := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if missing[path] {
+			lines = append(lines, fmt.Sprintf("import %s;", protoparse.Quote(path)))
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []protoparse.Edit{insertAfter(file, after, lines)}
+}
+
+// annotate sets the annotation option of a message, enum or service to its original name, as the first declaration of
+// its body. A previous annotation is removed, wherever it is in the body.
+func annotate(file *protoparse.File, decls []protoparse.Node, body protoparse.Block, span protoparse.Span, depth int, annotation string, originalName string) []protoparse.Edit {
+	edits := make([]protoparse.Edit, 0)
+	var first protoparse.Node
+	for _, decl := range decls {
+		if opt, ok := decl.(*protoparse.Option); ok && isAnnotation(opt, annotation) {
+			edits = append(edits, protoparse.Edit{Span: protoparse.LineSpan(file.Content, opt.Span)})
+			continue
+		}
+		if first == nil {
+			first = decl
+		}
+	}
+
+	option := fmt.Sprintf("option (%s) = %s;", annotation, protoparse.Quote(originalName))
+	afterBrace := body.Open + 1
+	at, endOfLine := file.LineEnd(afterBrace)
+	if !endOfLine {
+		// the body starts on the line of the brace, like message Empty {}
+		text := " " + option
+		if first == nil {
+			text += " "
+		}
+		return append(edits, protoparse.Edit{Span: protoparse.Span{Start: at, End: at}, Text: text})
+	}
+
+	indent := ""
+	if first != nil {
+		indent, _ = protoparse.Indentation(file.Content, first.Pos().Start)
+	}
+	if indent == "" {
+		if parent, ok := protoparse.Indentation(file.Content, span.Start); ok {
+			indent = parent + patchIndent
+		} else {
+			indent = strings.Repeat(patchIndent, depth)
+		}
+	}
+	return append(edits, protoparse.Edit{Span: protoparse.Span{Start: at, End: at}, Text: protoparse.LineBreak(file.Content) + indent + option})
+}
+
+// isAnnotation reports whether opt sets the extension annotation, written with or without a leading dot.
+func isAnnotation(opt *protoparse.Option, annotation string) bool {
+	name := strings.TrimPrefix(strings.TrimPrefix(opt.Name, "("), ".")
+	return name == strings.TrimPrefix(annotation, ".")+")"
+}
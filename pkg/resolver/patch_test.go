@@ -0,0 +1,636 @@
+package resolver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stormcat24/protodep/pkg/config"
+)
+
+func TestPatch(t *testing.T) {
+	p := &patcher{annotation: ".org.api.derived_from"}
+	result, err := p.patch([]byte(getProtoContent()), "upstream/path/to/proto", config.ProtoDepDependency{})
+	require.NoError(t, err)
+	require.Equal(t, getExpectedProtoContentPatched(), string(result))
+}
+
+func getProtoContent() string {
+	return `
+syntax = "proto3";
+
+package protodep.org.common;
+
+option java_multiple_files = true;
+option java_package = "com.protodep.org.common";
+
+import "google/protobuf/wrappers.proto";
+
+// A common thing
+
+//This Is 2
+message Two {
+    google.protobuf.StringValue one = 1;
+	google.protobuf.IntValue two = 2;
+}
+
+//thr33
+message Three {
+    message Count {
+        uint32 total = 1;
+    }
+    google.protobuf.StringValue one = 1;
+    google.protobuf.IntValue two = 2;
+    Count three = 3;
+}
+
+//This Is The One
+message One {
+	option (.org.api.derived_from) = "some.other.Ancestor";
+    boolean one = 1;
+}
+
+`
+}
+
+func getExpectedProtoContentPatched() string {
+	return `
+syntax = "proto3";
+
+package upstream.path.to;
+
+option java_multiple_files = true;
+option java_package = "com.upstream.path.to";
+
+import "google/protobuf/wrappers.proto";
+
+// A common thing
+
+//This Is 2
+message Two {
+    option (.org.api.derived_from) = "protodep.org.common.Two";
+    google.protobuf.StringValue one = 1;
+	google.protobuf.IntValue two = 2;
+}
+
+//thr33
+message Three {
+    option (.org.api.derived_from) = "protodep.org.common.Three";
+    message Count {
+        option (.org.api.derived_from) = "protodep.org.common.Three.Count";
+        uint32 total = 1;
+    }
+    google.protobuf.StringValue one = 1;
+    google.protobuf.IntValue two = 2;
+    Count three = 3;
+}
+
+//This Is The One
+message One {
+    option (.org.api.derived_from) = "protodep.org.common.One";
+    boolean one = 1;
+}
+
+`
+}
+
+func TestPatchTrickyDeclarations(t *testing.T) {
+	content := `syntax = "proto2";
+
+package acme.billing;
+
+// closing a } in a comment
+message Invoice { optional string id = 1; }
+message Empty {}
+
+message
+  Payment
+{ // the payment
+  optional string note = 1 [default = "}{"];
+  enum State {
+    STATE_UNKNOWN = 0;
+  }
+  /* } */
+  message Line {
+    optional int32 quantity = 1;
+  }
+  optional State state = 2;
+}
+`
+	expected := `syntax = "proto2";
+
+package vendor.acme;
+
+// closing a } in a comment
+message Invoice { option (api.derived_from) = "acme.billing.Invoice"; optional string id = 1; }
+message Empty { option (api.derived_from) = "acme.billing.Empty"; }
+
+message
+  Payment
+{ // the payment
+  option (api.derived_from) = "acme.billing.Payment";
+  optional string note = 1 [default = "}{"];
+  enum State {
+    STATE_UNKNOWN = 0;
+  }
+  /* } */
+  message Line {
+    option (api.derived_from) = "acme.billing.Payment.Line";
+    optional int32 quantity = 1;
+  }
+  optional State state = 2;
+}
+`
+	result, err := (&patcher{annotation: "api.derived_from"}).patch([]byte(content), "vendor/acme/billing.proto", config.ProtoDepDependency{})
+	require.NoError(t, err)
+	require.Equal(t, expected, string(result))
+}
+
+func TestPatchEditions(t *testing.T) {
+	content := "edition = \"2023\";\r\npackage acme;\r\noption java_package = \"com.acme\";\r\n\r\nmessage A {\r\n\tint32 a = 1;\r\n}\r\n"
+	expected := "edition = \"2023\";\r\npackage vendor;\r\noption java_package = \"com.vendor\";\r\n\r\nmessage A {\r\n\toption (api.derived_from) = \"acme.A\";\r\n\tint32 a = 1;\r\n}\r\n"
+	result, err := (&patcher{annotation: "api.derived_from"}).patch([]byte(content), "vendor/a.proto", config.ProtoDepDependency{})
+	require.NoError(t, err)
+	require.Equal(t, expected, string(result))
+}
+
+func TestPatchEdition2024(t *testing.T) {
+	content := "edition = \"2024\";\npackage acme;\nimport option \"acme/options.proto\";\n\nexport message A {\n  local enum Kind { KIND_UNSPECIFIED = 0; }\n}\n"
+	expected := "edition = \"2024\";\npackage vendor;\nimport option \"acme/options.proto\";\n\nexport message A {\n  option (api.derived_from) = \"acme.A\";\n  local enum Kind { KIND_UNSPECIFIED = 0; }\n}\n"
+	result, err := (&patcher{annotation: "api.derived_from"}).patch([]byte(content), "vendor/a.proto", config.ProtoDepDependency{})
+	require.NoError(t, err)
+	require.Equal(t, expected, string(result))
+}
+
+func TestPatchInvalidProto(t *testing.T) {
+	_, err := (&patcher{annotation: "api.derived_from"}).patch([]byte("message A {"), "vendor/a.proto", config.ProtoDepDependency{})
+	require.Error(t, err)
+}
+
+func TestPatchOptions(t *testing.T) {
+	targetDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(targetDir, "go.mod"), []byte("module github.com/acme/svc\n\ngo 1.20\n"), 0644))
+	p, err := newPatcher(&config.ProtoDep{
+		ProtoOutdir:     "proto",
+		PatchAnnotation: "api.derived_from",
+		PatchOptions: map[string]string{
+			"go_package":       "{{.GoModule}}/{{.Dir}};{{goname .Dir}}",
+			"java_package":     "com.acme.{{.Package}}",
+			"csharp_namespace": "{{pascal .Package}}",
+			"swift_prefix":     "",
+		},
+	}, targetDir)
+	require.NoError(t, err)
+
+	content := `syntax = "proto3";
+
+package upstream.billing.v1;
+
+option go_package = "github.com/upstream/billing/v1;billingv1"; // upstream module
+option java_package = "com.upstream.billing.v1";
+option swift_prefix = "UP";
+
+message Invoice {
+  string id = 1;
+}
+`
+	expected := `syntax = "proto3";
+
+package proto.billing;
+
+option go_package = "github.com/acme/svc/proto/billing;billing"; // upstream module
+option java_package = "com.acme.proto.billing";
+option csharp_namespace = "Proto.Billing";
+
+message Invoice {
+  option (api.derived_from) = "upstream.billing.v1.Invoice";
+  string id = 1;
+}
+`
+	result, err := p.patch([]byte(content), "proto/billing/invoice.proto", config.ProtoDepDependency{})
+	require.NoError(t, err)
+	require.Equal(t, expected, string(result))
+
+	// options are added after the package when the file has none
+	result, err = p.patch([]byte("syntax = \"proto3\";\npackage upstream;\n"), "proto/billing/invoice.proto", config.ProtoDepDependency{})
+	require.NoError(t, err)
+	require.Equal(t, `syntax = "proto3";
+package proto.billing;
+option go_package = "github.com/acme/svc/proto/billing;billing";
+option java_package = "com.acme.proto.billing";
+option csharp_namespace = "Proto.Billing";
+`, string(result))
+}
+
+func TestPatchOptionsWithoutGoModule(t *testing.T) {
+	protodep := &config.ProtoDep{
+		ProtoOutdir:     "proto",
+		PatchAnnotation: "api.derived_from",
+		PatchOptions:    map[string]string{"go_package": "{{.GoModule}}/{{.Dir}}"},
+	}
+	_, err := newPatcher(protodep, t.TempDir())
+	require.ErrorContains(t, err, "patch_options go_package uses .GoModule")
+
+	// templates which don't use it need no go.mod
+	protodep.PatchOptions = map[string]string{"go_package": "github.com/acme/svc/{{.Dir}}"}
+	_, err = newPatcher(protodep, t.TempDir())
+	require.NoError(t, err)
+}
+
+func TestPatchPackageTemplates(t *testing.T) {
+	gateway := config.ProtoDepDependency{Target: "github.com/grpc-ecosystem/grpc-gateway", Path: "grpc-gateway"}
+	common := config.ProtoDepDependency{Target: "github.com/acme/common", Path: "common", PatchPackage: "acme.{{.OriginalPackage}}"}
+	p, err := newPatcher(&config.ProtoDep{
+		ProtoOutdir:     "proto",
+		PatchAnnotation: "api.derived_from",
+		PatchPackage:    "vendor.{{.Path}}",
+		Dependencies:    []config.ProtoDepDependency{gateway, common},
+	}, t.TempDir())
+	require.NoError(t, err)
+
+	content := []byte("syntax = \"proto3\";\npackage grpc.gateway.v1;\n")
+	result, err := p.patch(content, "proto/grpc-gateway/2023/a.proto", gateway)
+	require.NoError(t, err)
+	require.Equal(t, "syntax = \"proto3\";\npackage vendor.proto.grpc_gateway._2023;\n", string(result))
+
+	result, err = p.patch(content, "proto/common/a.proto", common)
+	require.NoError(t, err)
+	require.Equal(t, "syntax = \"proto3\";\npackage acme.grpc.gateway.v1;\n", string(result))
+
+	// without template, the directory is sanitized too
+	result, err = (&patcher{annotation: "api.derived_from"}).patch(content, "proto/grpc-gateway/a.proto", gateway)
+	require.NoError(t, err)
+	require.Equal(t, "syntax = \"proto3\";\npackage proto.grpc_gateway;\n", string(result))
+}
+
+func TestPatchReferences(t *testing.T) {
+	common := config.ProtoDepDependency{Target: "github.com/upstream/common", Path: "common"}
+	billing := config.ProtoDepDependency{Target: "github.com/upstream/billing", Path: "billing"}
+	p, err := newPatcher(&config.ProtoDep{
+		ProtoOutdir:     "proto",
+		PatchAnnotation: "api.derived_from",
+		Dependencies:    []config.ProtoDepDependency{common, billing},
+	}, t.TempDir())
+	require.NoError(t, err)
+
+	money := vendoredFile{dep: common, path: "proto/common/money.proto", content: []byte(`syntax = "proto3";
+package upstream.common.v1;
+message Money { string currency = 1; }
+message Pair { Money a = 1; upstream.common.v1.Money b = 2; }
+extend google.protobuf.FieldOptions { string unit = 50000; }
+`)}
+	invoice := vendoredFile{dep: billing, path: "proto/billing/invoice.proto", content: []byte(`syntax = "proto3";
+package upstream.billing.v1;
+option (upstream.common.v1.unit) = { [upstream.common.v1.unit]: "EUR" [type.googleapis.com/upstream.common.v1.Money] {} tags: [1] };
+message Invoice {
+  upstream.common.v1.Money total = 1;
+  .upstream.common.v1.Money tax = 2 [(upstream.common.v1.unit) = "EUR"];
+  common.v1.Money fee = 3;
+  map<string, upstream.common.v1.Money> lines = 4;
+  google.protobuf.Timestamp at = 5;
+  Invoice previous = 6;
+}
+extend upstream.common.v1.Money { string note = 100; }
+service Billing {
+  rpc Pay(stream upstream.common.v1.Money) returns (Invoice);
+}
+`)}
+	require.NoError(t, p.index([]vendoredFile{money, invoice}))
+
+	result, err := p.patch(money.content, money.path, common)
+	require.NoError(t, err)
+	require.Equal(t, `syntax = "proto3";
+package proto.common;
+message Money { option (api.derived_from) = "upstream.common.v1.Money"; string currency = 1; }
+message Pair { option (api.derived_from) = "upstream.common.v1.Pair"; Money a = 1; proto.common.Money b = 2; }
+extend google.protobuf.FieldOptions { string unit = 50000; }
+`, string(result))
+
+	result, err = p.patch(invoice.content, invoice.path, billing)
+	require.NoError(t, err)
+	require.Equal(t, `syntax = "proto3";
+package proto.billing;
+option (proto.common.unit) = { [proto.common.unit]: "EUR" [type.googleapis.com/proto.common.Money] {} tags: [1] };
+message Invoice {
+  option (api.derived_from) = "upstream.billing.v1.Invoice";
+  proto.common.Money total = 1;
+  .proto.common.Money tax = 2 [(proto.common.unit) = "EUR"];
+  proto.common.Money fee = 3;
+  map<string, proto.common.Money> lines = 4;
+  google.protobuf.Timestamp at = 5;
+  Invoice previous = 6;
+}
+extend proto.common.Money { string note = 100; }
+service Billing {
+  rpc Pay(stream proto.common.Money) returns (Invoice);
+}
+`, string(result))
+}
+
+func TestPatchReferencesShadowedByPatchedPackages(t *testing.T) {
+	googleapis := config.ProtoDepDependency{Target: "github.com/googleapis/googleapis", Path: "googleapis"}
+	protobuf := config.ProtoDepDependency{Target: "github.com/protocolbuffers/protobuf/src", Path: "protobuf"}
+	p, err := newPatcher(&config.ProtoDep{
+		ProtoOutdir:     "proto",
+		PatchAnnotation: "api.derived_from",
+		Dependencies:    []config.ProtoDepDependency{googleapis, protobuf},
+	}, t.TempDir())
+	require.NoError(t, err)
+
+	descriptor := vendoredFile{dep: protobuf, relativeDest: "/google/protobuf/descriptor.proto", path: "proto/protobuf/google/protobuf/descriptor.proto", content: []byte(`syntax = "proto2";
+package google.protobuf;
+message MethodOptions { extensions 1000 to max; }
+`)}
+	http := vendoredFile{dep: googleapis, relativeDest: "/google/api/http.proto", path: "proto/googleapis/google/api/http.proto", content: []byte(`syntax = "proto3";
+package google.api;
+message HttpRule { string get = 2; }
+`)}
+	annotations := vendoredFile{dep: googleapis, relativeDest: "/google/api/annotations.proto", path: "proto/googleapis/google/api/annotations.proto", content: []byte(`syntax = "proto3";
+package google.api;
+import "google/api/http.proto";
+import "google/protobuf/descriptor.proto";
+extend google.protobuf.MethodOptions {
+  HttpRule http = 72295728;
+}
+message Details {
+  google.protobuf.Any detail = 1;
+  .google.protobuf.Any absolute = 2;
+  google.api.HttpRule rule = 3;
+  Timestamp local = 4;
+}
+`)}
+	require.NoError(t, p.index([]vendoredFile{descriptor, http, annotations}))
+
+	result, err := p.patch(annotations.content, annotations.path, googleapis)
+	require.NoError(t, err)
+	require.Equal(t, `syntax = "proto3";
+package proto.googleapis.google.api;
+import "proto/googleapis/google/api/http.proto";
+import "google/protobuf/descriptor.proto";
+extend .google.protobuf.MethodOptions {
+  HttpRule http = 72295728;
+}
+message Details {
+  option (api.derived_from) = "google.api.Details";
+  .google.protobuf.Any detail = 1;
+  .google.protobuf.Any absolute = 2;
+  google.api.HttpRule rule = 3;
+  Timestamp local = 4;
+}
+`, string(result))
+
+	result, err = p.patch(descriptor.content, descriptor.path, protobuf)
+	require.NoError(t, err)
+	require.Equal(t, string(descriptor.content), string(result))
+}
+
+func TestSymbolTableResolve(t *testing.T) {
+	table := newSymbolTable()
+	table.addPackage("a.b")
+	table.addPackage("a.b.a")
+	table.symbols["a.b.M"] = true
+	table.symbols["c.M"] = true
+
+	require.Equal(t, "a.b.M", table.resolve("M", "a.b.N"))
+	require.Equal(t, "a.b.M", table.resolve("b.M", "a.b"))
+	require.Equal(t, "a.b.M", table.resolve(".a.b.M", "c"))
+	// a is first found as a.b.a, protoc doesn't look further
+	require.Equal(t, "", table.resolve("a.b.M", "a.b"))
+	require.Equal(t, "", table.resolve("google.protobuf.Timestamp", "a.b"))
+
+	require.Equal(t, "a.b.a.b.M", table.bind("a.b.M", "a.b"))
+	require.Equal(t, "", table.bind("google.protobuf.Timestamp", "a.b"))
+}
+
+func TestPatchImports(t *testing.T) {
+	common := config.ProtoDepDependency{Target: "github.com/upstream/common/proto", Path: "common"}
+	api := config.ProtoDepDependency{Target: "github.com/upstream/api", Path: "api"}
+	p, err := newPatcher(&config.ProtoDep{
+		ProtoOutdir:     "./proto",
+		PatchAnnotation: "api.derived_from",
+		Dependencies:    []config.ProtoDepDependency{common, api},
+	}, t.TempDir())
+	require.NoError(t, err)
+
+	invoice := vendoredFile{dep: api, relativeDest: "/api/v1/invoice.proto", path: "proto/api/api/v1/invoice.proto", content: []byte(`syntax = "proto3";
+package upstream.api.v1;
+import "upstream/common/v1/money.proto";
+import weak "proto/upstream/common/v1/unit.proto";
+import public "github.com/upstream/api/api/v1/line.proto";
+import "google/protobuf/timestamp.proto";
+import "upstream/common.proto";
+`)}
+	require.NoError(t, p.index([]vendoredFile{
+		{dep: common, relativeDest: "/upstream/common/v1/money.proto", path: "proto/common/upstream/common/v1/money.proto"},
+		{dep: common, relativeDest: "/upstream/common/v1/unit.proto", path: "proto/common/upstream/common/v1/unit.proto"},
+		{dep: api, relativeDest: "/api/v1/line.proto", path: "proto/api/api/v1/line.proto"},
+		invoice,
+	}))
+
+	result, err := p.patch(invoice.content, invoice.path, api)
+	require.NoError(t, err)
+	require.Equal(t, `syntax = "proto3";
+package proto.api.api.v1;
+import "proto/common/upstream/common/v1/money.proto";
+import weak "proto/common/upstream/common/v1/unit.proto";
+import public "proto/api/api/v1/line.proto";
+import "google/protobuf/timestamp.proto";
+import "upstream/common.proto";
+`, string(result))
+}
+
+func TestPatchImportsDeduplicated(t *testing.T) {
+	common := config.ProtoDepDependency{Target: "github.com/upstream/common/proto", Path: "common"}
+	p := &patcher{annotation: "api.derived_from"}
+	file := vendoredFile{dep: common, relativeDest: "/upstream/common/v1/invoice.proto", path: "proto/common/upstream/common/v1/invoice.proto", content: []byte(`import "upstream/common/v1/money.proto";
+import "github.com/upstream/common/proto/upstream/common/v1/money.proto";
+import "upstream/common/v1/unit.proto"; import "proto/common/upstream/common/v1/money.proto";
+`)}
+	require.NoError(t, p.index([]vendoredFile{
+		{dep: common, relativeDest: "/upstream/common/v1/money.proto", path: "proto/common/upstream/common/v1/money.proto"},
+		{dep: common, relativeDest: "/upstream/common/v1/unit.proto", path: "proto/common/upstream/common/v1/unit.proto"},
+		file,
+	}))
+
+	// the imports which resolve to an already imported file are removed
+	result, err := p.patch(file.content, file.path, common)
+	require.NoError(t, err)
+	require.Equal(t, `import "proto/common/upstream/common/v1/money.proto";
+import "proto/common/upstream/common/v1/unit.proto";
+`, string(result))
+}
+
+func TestPatchImportsPreferOwnDependency(t *testing.T) {
+	a := config.ProtoDepDependency{Target: "github.com/acme/a", Path: "a"}
+	b := config.ProtoDepDependency{Target: "github.com/acme/b", Path: "b"}
+	p := &patcher{annotation: "api.derived_from"}
+	file := vendoredFile{dep: b, relativeDest: "/main.proto", path: "proto/b/main.proto", content: []byte("import \"shared.proto\";\n")}
+	require.NoError(t, p.index([]vendoredFile{
+		{dep: a, relativeDest: "/shared.proto", path: "proto/a/shared.proto"},
+		{dep: b, relativeDest: "/shared.proto", path: "proto/b/shared.proto"},
+		file,
+	}))
+
+	result, err := p.patch(file.content, file.path, b)
+	require.NoError(t, err)
+	require.Equal(t, "import \"proto/b/shared.proto\";\n", string(result))
+}
+
+func TestPatchAnnotationImport(t *testing.T) {
+	p, err := newPatcher(&config.ProtoDep{
+		ProtoOutdir:          "./proto",
+		PatchAnnotation:      "acme.api.derived_from",
+		PatchAnnotationField: 50001,
+	}, t.TempDir())
+	require.NoError(t, err)
+
+	result, err := p.patch([]byte(`syntax = "proto3";
+package upstream;
+import "google/protobuf/timestamp.proto";
+message A {}
+`), "proto/vendor/a.proto", config.ProtoDepDependency{})
+	require.NoError(t, err)
+	require.Equal(t, `syntax = "proto3";
+package proto.vendor;
+import "google/protobuf/timestamp.proto";
+import "proto/acme/api/derived_from.proto";
+message A { option (acme.api.derived_from) = "upstream.A"; }
+`, string(result))
+
+	// after the package without imports, once
+	result, err = p.patch([]byte("syntax = \"proto3\";\npackage upstream;\nmessage A {}\n"), "proto/vendor/a.proto", config.ProtoDepDependency{})
+	require.NoError(t, err)
+	require.Equal(t, "syntax = \"proto3\";\npackage proto.vendor;\nimport \"proto/acme/api/derived_from.proto\";\nmessage A { option (acme.api.derived_from) = \"upstream.A\"; }\n", string(result))
+	again, err := p.patch(result, "proto/vendor/a.proto", config.ProtoDepDependency{})
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(again), "derived_from.proto"))
+
+	// files without messages don't need it
+	result, err = p.patch([]byte("syntax = \"proto3\";\npackage upstream;\nenum E { E_UNKNOWN = 0; }\n"), "proto/vendor/a.proto", config.ProtoDepDependency{})
+	require.NoError(t, err)
+	require.NotContains(t, string(result), "import")
+}
+
+func TestAnnotationProto(t *testing.T) {
+	file, content := annotationProto(config.Annotation{Option: ".acme.api.derived_from", Extendee: "google.protobuf.MessageOptions", Kind: "messages"}, 50001)
+	require.Equal(t, "acme/api/derived_from.proto", file)
+	require.Equal(t, `// Code generated by protodep. DO NOT EDIT.
+// The annotation smart-patch sets on vendored messages, with their original full name.
+
+syntax = "proto3";
+
+package acme.api;
+
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.MessageOptions {
+    string derived_from = 50001;
+}
+`, string(content))
+
+	file, content = annotationProto(config.Annotation{Option: "enum_of", Extendee: "google.protobuf.EnumOptions", Kind: "enums"}, 50001)
+	require.Equal(t, "enum_of.proto", file)
+	require.NotContains(t, string(content), "package")
+	require.Contains(t, string(content), "extend google.protobuf.EnumOptions {\n    string enum_of = 50001;\n}\n")
+}
+
+func TestPatchEnumAndServiceAnnotations(t *testing.T) {
+	p, err := newPatcher(&config.ProtoDep{
+		ProtoOutdir:            "proto",
+		PatchAnnotation:        "api.derived_from",
+		PatchEnumAnnotation:    "api.enum_of",
+		PatchServiceAnnotation: "api.service_of",
+		PatchAnnotationField:   50000,
+	}, t.TempDir())
+	require.NoError(t, err)
+
+	result, err := p.patch([]byte(`syntax = "proto3";
+package upstream.v1;
+
+enum State { STATE_UNKNOWN = 0; }
+
+message Order {
+  enum Kind {
+    option (api.enum_of) = "stale";
+    KIND_UNKNOWN = 0;
+  }
+}
+
+service Orders {
+  rpc Get(Order) returns (Order);
+}
+`), "proto/vendor/orders.proto", config.ProtoDepDependency{})
+	require.NoError(t, err)
+	require.Equal(t, `syntax = "proto3";
+package proto.vendor;
+import "proto/api/derived_from.proto";
+import "proto/api/enum_of.proto";
+import "proto/api/service_of.proto";
+
+enum State { option (api.enum_of) = "upstream.v1.State"; STATE_UNKNOWN = 0; }
+
+message Order {
+  option (api.derived_from) = "upstream.v1.Order";
+  enum Kind {
+    option (api.enum_of) = "upstream.v1.Order.Kind";
+    KIND_UNKNOWN = 0;
+  }
+}
+
+service Orders {
+  option (api.service_of) = "upstream.v1.Orders";
+  rpc Get(Order) returns (Order);
+}
+`, string(result))
+
+	// enums and services are left alone without their annotation
+	result, err = (&patcher{annotation: "api.derived_from"}).patch([]byte("package a;\nenum E { E_UNKNOWN = 0; }\nservice S {}\n"), "proto/a.proto", config.ProtoDepDependency{})
+	require.NoError(t, err)
+	require.Equal(t, "package proto;\nenum E { E_UNKNOWN = 0; }\nservice S {}\n", string(result))
+}
+
+func TestPatchSkipsDependenciesAndWellKnownTypes(t *testing.T) {
+	disabled := false
+	protobuf := config.ProtoDepDependency{Target: "github.com/protocolbuffers/protobuf/src", Path: "protobuf"}
+	vendor := config.ProtoDepDependency{Target: "github.com/acme/vendor", Path: "vendor", Patch: &disabled}
+	api := config.ProtoDepDependency{Target: "github.com/acme/api", Path: "api"}
+	p, err := newPatcher(&config.ProtoDep{
+		ProtoOutdir:     "proto",
+		PatchAnnotation: "api.derived_from",
+		Dependencies:    []config.ProtoDepDependency{protobuf, vendor, api},
+	}, t.TempDir())
+	require.NoError(t, err)
+
+	empty := vendoredFile{dep: protobuf, relativeDest: "/google/protobuf/empty.proto", path: "proto/protobuf/google/protobuf/empty.proto", content: []byte("syntax = \"proto3\";\npackage google.protobuf;\nmessage Empty {}\n")}
+	legacy := vendoredFile{dep: vendor, relativeDest: "/legacy/v1/legacy.proto", path: "proto/vendor/legacy/v1/legacy.proto", content: []byte("syntax = \"proto3\";\npackage legacy.v1;\nmessage Legacy {}\n")}
+	orders := vendoredFile{dep: api, relativeDest: "/acme/orders.proto", path: "proto/api/acme/orders.proto", content: []byte(`syntax = "proto3";
+package acme;
+import "google/protobuf/empty.proto";
+import "legacy/v1/legacy.proto";
+message Order { google.protobuf.Empty empty = 1; legacy.v1.Legacy legacy = 2; }
+`)}
+	require.NoError(t, p.index([]vendoredFile{empty, legacy, orders}))
+
+	for _, f := range []vendoredFile{empty, legacy} {
+		result, err := p.patch(f.content, f.path, f.dep)
+		require.NoError(t, err)
+		require.Equal(t, string(f.content), string(result))
+	}
+	result, err := p.patch(orders.content, orders.path, api)
+	require.NoError(t, err)
+	require.Equal(t, `syntax = "proto3";
+package proto.api.acme;
+import "google/protobuf/empty.proto";
+import "legacy/v1/legacy.proto";
+message Order { option (api.derived_from) = "acme.Order"; google.protobuf.Empty empty = 1; legacy.v1.Legacy legacy = 2; }
+`, string(result))
+	require.Len(t, p.mapping().Files, 1)
+
+	// files of a disabled dependency aren't parsed
+	result, err = p.patch([]byte("not a proto"), legacy.path, vendor)
+	require.NoError(t, err)
+	require.Equal(t, "not a proto", string(result))
+}
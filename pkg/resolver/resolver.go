@@ -2,6 +2,7 @@ package resolver
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,6 +11,7 @@ import (
 	"github.com/BurntSushi/toml"
 	"github.com/gobwas/glob"
 	"github.com/stormcat24/protodep/pkg/auth"
+	"github.com/stormcat24/protodep/pkg/cache"
 	"github.com/stormcat24/protodep/pkg/config"
 	"github.com/stormcat24/protodep/pkg/logger"
 	"github.com/stormcat24/protodep/pkg/repository"
@@ -22,6 +24,7 @@ type protoResource struct {
 
 type Resolver interface {
 	Resolve(forceUpdate bool, cleanupCache bool) error
+	AuthStatus(probe bool) ([]HostStatus, error)
 
 	SetHttpsAuthProvider(provider auth.AuthProvider)
 	SetSshAuthProvider(provider auth.AuthProvider)
@@ -32,11 +35,22 @@ type resolver struct {
 
 	httpsProvider auth.AuthProvider
 	sshProvider   auth.AuthProvider
+
+	// explicitHttps is set when httpsProvider holds credentials meant for every host.
+	explicitHttps bool
+	// credentialHelper caches whether git has a credential helper configured.
+	credentialHelper *bool
+
+	rewrites []auth.URLRewrite
+
+	// hostProviders caches the providers selected per host, by credential source and key.
+	hostProviders map[string]hostProvider
 }
 
 func New(conf *Config) (Resolver, error) {
 	s := &resolver{
-		conf: conf,
+		conf:          conf,
+		hostProviders: make(map[string]hostProvider),
 	}
 
 	err := s.initAuthProviders()
@@ -55,23 +69,22 @@ func (s *resolver) Resolve(forceUpdate bool, cleanupCache bool) error {
 		return err
 	}
 
+	s.rewrites = urlRewrites(protodep.URLRewrites, s.conf.URLRewrites)
+
+	patcher, err := newPatcher(protodep, s.conf.TargetDir)
+	if err != nil {
+		return err
+	}
+
 	newdeps := make([]config.ProtoDepDependency, 0, len(protodep.Dependencies))
-	protodepDir := filepath.Join(s.conf.HomeDir, ".protodep")
+	vendored := make([]vendoredFile, 0)
+	protodepDir := cache.Dir(s.conf.HomeDir, s.conf.CacheDir)
+	depCache := cache.New(protodepDir)
 
-	_, err = os.Stat(protodepDir)
-	if cleanupCache && err == nil {
-		files, err := os.ReadDir(protodepDir)
-		if err != nil {
+	if cleanupCache {
+		if err := depCache.Clean(); err != nil {
 			return err
 		}
-		for _, file := range files {
-			if file.IsDir() {
-				dirpath := filepath.Join(protodepDir, file.Name())
-				if err := os.RemoveAll(dirpath); err != nil {
-					return err
-				}
-			}
-		}
 	}
 
 	outdir := filepath.Join(s.conf.OutputDir, protodep.ProtoOutdir)
@@ -80,27 +93,34 @@ func (s *resolver) Resolve(forceUpdate bool, cleanupCache bool) error {
 	}
 
 	for _, dep := range protodep.Dependencies {
-		var authProvider auth.AuthProvider
-
-		if s.conf.UseHttps {
-			authProvider = s.httpsProvider
-		} else {
-			switch dep.Protocol {
-			case "https":
-				authProvider = s.httpsProvider
-			case "ssh", "":
-				authProvider = s.sshProvider
-			default:
-				return fmt.Errorf("%s protocol is not accepted (ssh or https only)", dep.Protocol)
+		authProvider, _, err := s.dependencyAuthProvider(dep)
+		if err != nil {
+			return err
+		}
+
+		protocol := dep.Protocol
+		opts := []repository.GitOption{
+			repository.WithSubmoduleAuth(func(reponame string) (auth.AuthProvider, error) {
+				return s.authProvider(reponame, protocol)
+			}),
+		}
+		if dep.RequireSignature {
+			keyring, err := s.readKeyring(dep, protodep)
+			if err != nil {
+				return err
 			}
+			opts = append(opts, repository.WithSignatureKeyring(keyring))
 		}
 
-		gitrepo := repository.NewGit(protodepDir, dep, authProvider)
+		gitrepo := repository.NewGit(protodepDir, dep, authProvider, opts...)
 
 		repo, err := gitrepo.Open()
 		if err != nil {
 			return err
 		}
+		if err := depCache.Touch(dep.Repository()); err != nil {
+			logger.Warn("failed to record cache usage of %s: %v", dep.Repository(), err)
+		}
 
 		sources := make([]protoResource, 0)
 
@@ -139,32 +159,84 @@ func (s *resolver) Resolve(forceUpdate bool, cleanupCache bool) error {
 			if err != nil {
 				return err
 			}
-
-			if len(protodep.PatchAnnotation) > 0 {
-				content = patchProtoFile(content, filepath.Join(protodep.ProtoOutdir, dep.Path, s.relativeDest), protodep.PatchAnnotation, protodep.Dependencies, protodep.ProtoOutdir)
+			if isLFSPointer(content) {
+				return fmt.Errorf("%s is a Git LFS pointer, not a proto file: files stored in Git LFS can't be vendored", s.source)
 			}
 
-			if err := writeFileWithDirectory(outpath, content, 0644); err != nil {
-				return err
-			}
+			vendored = append(vendored, vendoredFile{
+				dep:          dep,
+				source:       s.source,
+				relativeDest: s.relativeDest,
+				path:         filepath.ToSlash(filepath.Join(protodep.ProtoOutdir, dep.Path, s.relativeDest)),
+				outpath:      outpath,
+				content:      content,
+			})
 		}
 
 		newdeps = append(newdeps, config.ProtoDepDependency{
-			Target:   repo.Dep.Target,
-			Branch:   repo.Dep.Branch,
-			Revision: repo.Hash,
-			Path:     repo.Dep.Path,
-			Includes: repo.Dep.Includes,
-			Ignores:  repo.Dep.Ignores,
-			Protocol: repo.Dep.Protocol,
-			Subgroup: repo.Dep.Subgroup,
+			Target:           repo.Dep.Target,
+			Branch:           repo.Branch,
+			Revision:         repo.Hash,
+			Path:             repo.Dep.Path,
+			Includes:         repo.Dep.Includes,
+			Ignores:          repo.Dep.Ignores,
+			Protocol:         repo.Dep.Protocol,
+			Subgroup:         repo.Dep.Subgroup,
+			Submodules:       repo.Dep.Submodules,
+			RequireSignature: repo.Dep.RequireSignature,
+			SignatureKeyring: repo.Dep.SignatureKeyring,
+			Signer:           repo.Signer,
+			SignedTag:        repo.SignedTag,
+			IdentityFile:     repo.Dep.IdentityFile,
+			KnownHosts:       repo.Dep.KnownHosts,
+			HostKeyPolicy:    repo.Dep.HostKeyPolicy,
+			PatchPackage:     repo.Dep.PatchPackage,
+			Patch:            repo.Dep.Patch,
 		})
 	}
 
+	// references between vendored files are patched once all of them are known
+	if len(protodep.PatchAnnotation) > 0 {
+		if err := patcher.index(vendored); err != nil {
+			return err
+		}
+	}
+	for _, f := range vendored {
+		content := f.content
+		if len(protodep.PatchAnnotation) > 0 {
+			content, err = patcher.patch(content, f.path, f.dep)
+			if err != nil {
+				return fmt.Errorf("patch %s: %w", f.source, err)
+			}
+		}
+		if err := writeFileWithDirectory(f.outpath, content, 0644); err != nil {
+			return err
+		}
+	}
+	if len(protodep.PatchAnnotation) > 0 && protodep.PatchAnnotationField != 0 {
+		if err := writeAnnotationProtos(outdir, protodep, vendored); err != nil {
+			return err
+		}
+	}
+	if len(protodep.PatchAnnotation) > 0 {
+		// written even without patch_mapping, files without annotations can't be unpatched otherwise
+		if err := writePatchMapping(patchMappingPath(protodep, s.conf.OutputDir), patcher.mapping()); err != nil {
+			return err
+		}
+	}
+
 	newProtodep := config.ProtoDep{
-		ProtoOutdir:     protodep.ProtoOutdir,
-		PatchAnnotation: protodep.PatchAnnotation,
-		Dependencies:    newdeps,
+		ProtoOutdir:            protodep.ProtoOutdir,
+		PatchAnnotation:        protodep.PatchAnnotation,
+		PatchEnumAnnotation:    protodep.PatchEnumAnnotation,
+		PatchServiceAnnotation: protodep.PatchServiceAnnotation,
+		PatchAnnotationField:   protodep.PatchAnnotationField,
+		PatchPackage:           protodep.PatchPackage,
+		PatchOptions:           protodep.PatchOptions,
+		PatchMapping:           protodep.PatchMapping,
+		SignatureKeyring:       protodep.SignatureKeyring,
+		URLRewrites:            protodep.URLRewrites,
+		Dependencies:           newdeps,
 	}
 
 	if dep.IsNeedWriteLockFile() {
@@ -176,37 +248,407 @@ func (s *resolver) Resolve(forceUpdate bool, cleanupCache bool) error {
 	return nil
 }
 
+// writeAnnotationProtos generates the protos defining the smart-patch annotations in outdir.
+func writeAnnotationProtos(outdir string, protodep *config.ProtoDep, vendored []vendoredFile) error {
+	for _, annotation := range protodep.PatchAnnotations() {
+		file, content := annotationProto(annotation, protodep.PatchAnnotationField)
+		outpath := filepath.Join(outdir, file)
+		for _, f := range vendored {
+			if filepath.Clean(f.outpath) == filepath.Clean(outpath) {
+				return fmt.Errorf("the annotation proto %s would overwrite %s of %s", outpath, f.source, f.dep.Target)
+			}
+		}
+		logger.Info("generated %s defining %s", outpath, annotation.Option)
+		if err := writeFileWithDirectory(outpath, content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// internalPatchMapping is the mapping kept in proto_outdir for unpatch when patch_mapping is not configured.
+const internalPatchMapping = ".protodep_patch_mapping.json"
+
+// patchMappingPath returns where the patch mapping is written: patch_mapping, or internalPatchMapping in proto_outdir.
+func patchMappingPath(protodep *config.ProtoDep, outputDir string) string {
+	if protodep.PatchMapping != "" {
+		return filepath.Join(outputDir, protodep.PatchMapping)
+	}
+	return filepath.Join(outputDir, protodep.ProtoOutdir, internalPatchMapping)
+}
+
+// writePatchMapping writes the names of the patched files and declarations with their upstream names, as JSON.
+func writePatchMapping(path string, mapping patchMapping) error {
+	content, err := json.MarshalIndent(mapping, "", "  ")
+	if err != nil {
+		return err
+	}
+	logger.Info("wrote the mapping of %d patched names to %s", len(mapping.Symbols), path)
+	return writeFileWithDirectory(path, append(content, '\n'), 0644)
+}
+
+// readPatchMapping reads the mapping written by writePatchMapping.
+func readPatchMapping(path string) (*patchMapping, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mapping patchMapping
+	if err := json.Unmarshal(content, &mapping); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return &mapping, nil
+}
+
 func (s *resolver) SetHttpsAuthProvider(provider auth.AuthProvider) {
 	s.httpsProvider = provider
+	s.explicitHttps = true
 }
 
 func (s *resolver) SetSshAuthProvider(provider auth.AuthProvider) {
 	s.sshProvider = provider
 }
 
+// readKeyring loads the keyring a dependency's signature is verified with.
+func (s *resolver) readKeyring(dep config.ProtoDepDependency, protodep *config.ProtoDep) (string, error) {
+	path := dep.SignatureKeyring
+	if path == "" {
+		path = protodep.SignatureKeyring
+	}
+	content, err := os.ReadFile(s.projectPath(path))
+	if err != nil {
+		return "", fmt.Errorf("read signature keyring of %s: %w", dep.Target, err)
+	}
+	return string(content), nil
+}
+
+// authProvider selects the AuthProvider of a repository from its protocol and host.
+func (s *resolver) authProvider(reponame string, protocol string) (auth.AuthProvider, error) {
+	provider, _, err := s.selectAuth(reponame, protocol, sshSettings{})
+	return provider, err
+}
+
+// dependencyAuthProvider selects the AuthProvider of a dependency, honoring its own ssh settings.
+func (s *resolver) dependencyAuthProvider(dep config.ProtoDepDependency) (auth.AuthProvider, string, error) {
+	return s.selectAuth(dep.Repository(), dep.Protocol, sshSettings{
+		identityFile:  s.projectPath(dep.IdentityFile),
+		knownHosts:    s.projectPath(dep.KnownHosts),
+		hostKeyPolicy: dep.HostKeyPolicy,
+	})
+}
+
+// sshSettings are the ssh settings of a dependency, they win over the ones of its host.
+type sshSettings struct {
+	identityFile  string
+	knownHosts    string
+	hostKeyPolicy string
+}
+
+// selectAuth selects the AuthProvider of a repository and describes where its credentials come from.
+func (s *resolver) selectAuth(reponame string, protocol string, dep sshSettings) (auth.AuthProvider, string, error) {
+	// a session locks its host on https
+	_, hasSession := s.conf.Sessions[auth.RepositoryHost(reponame)]
+	useHttps := s.conf.UseHttps || hasSession
+	if !useHttps {
+		switch protocol {
+		case "https":
+			useHttps = true
+		case "ssh", "":
+		default:
+			return nil, "", fmt.Errorf("%s protocol is not accepted (ssh or https only)", protocol)
+		}
+	}
+
+	var provider auth.AuthProvider
+	var source string
+	var err error
+	if useHttps {
+		provider, source, err = s.httpsProviderFor(reponame)
+	} else {
+		provider, source, err = s.sshProviderFor(reponame, dep)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return auth.WithURLRewrites(provider, s.rewrites, s.hostAuth), source, nil
+}
+
+// hostAuth selects the credentials of a url which replaced the one of a repository, e.g. a mirror.
+// Only the credentials of the host are used, never those of the dependency.
+func (s *resolver) hostAuth(reponame string, protocol string) (auth.AuthProvider, error) {
+	var provider auth.AuthProvider
+	var err error
+	if protocol == "https" {
+		provider, _, err = s.httpsProviderFor(reponame)
+	} else {
+		provider, _, err = s.sshProviderFor(reponame, sshSettings{})
+	}
+	return provider, err
+}
+
+// hostProvider is a provider built for some hosts, with the description of its credentials.
+type hostProvider struct {
+	provider auth.AuthProvider
+	source   string
+}
+
+// cachedProvider returns the provider cached under key, building it on first use.
+func (s *resolver) cachedProvider(key string, source string, reponame string, build func() (auth.AuthProvider, error)) (auth.AuthProvider, string, error) {
+	if cached, ok := s.hostProviders[key]; ok {
+		return cached.provider, cached.source, nil
+	}
+	provider, err := build()
+	if err != nil {
+		return nil, "", err
+	}
+	logger.Info("using %s for %s", source, reponame)
+	s.hostProviders[key] = hostProvider{provider: provider, source: source}
+	return provider, source, nil
+}
+
+// httpsProviderFor picks the https credentials of a repository, by order of precedence:
+// the basic auth flags, environment variables, the credential or GitHub App configured for the host, the session,
+// the .netrc entry of the host and git's credential helper.
+func (s *resolver) httpsProviderFor(reponame string) (auth.AuthProvider, string, error) {
+	if s.explicitHttps {
+		return s.httpsProvider, "basic auth flags", nil
+	}
+
+	host := auth.RepositoryHost(reponame)
+	if cred, ok := auth.LookupEnvCredential(host, os.Getenv); ok {
+		return s.cachedProvider("env://"+host, "environment variable $"+cred.Source, host, func() (auth.AuthProvider, error) {
+			return auth.NewAuthProvider(auth.WithHTTPS(cred.Username, cred.Password)), nil
+		})
+	}
+	app, err := auth.LookupEnvGitHubApp(host, os.Getenv)
+	if err != nil {
+		return nil, "", err
+	}
+	if app != nil {
+		return s.githubAppProvider(*app, reponame, "environment variable $"+auth.EnvGitHubAppID)
+	}
+
+	key, cred, ok := config.LookupCredential(s.conf.Credentials, reponame)
+	if ok && cred.AppID != "" {
+		privateKey, err := os.ReadFile(s.homePath(cred.PrivateKeyFile))
+		if err != nil {
+			return nil, "", fmt.Errorf("read the private key of the github app of %s: %w", key, err)
+		}
+		app := auth.GitHubApp{
+			AppID:          cred.AppID,
+			InstallationID: cred.InstallationID,
+			PrivateKey:     privateKey,
+			APIURL:         cred.APIURL,
+		}
+		return s.githubAppProvider(app, reponame, fmt.Sprintf("github app of %s in the user config", key))
+	}
+	if ok && (cred.Username != "" || cred.Password != "") {
+		return s.cachedProvider("https://"+key, fmt.Sprintf("credentials of %s in the user config", key), reponame, func() (auth.AuthProvider, error) {
+			return auth.NewAuthProvider(auth.WithHTTPS(cred.Username, cred.Password)), nil
+		})
+	}
+
+	if session, ok := s.conf.Sessions[host]; ok {
+		return s.cachedProvider("session://"+host, "login session", host, func() (auth.AuthProvider, error) {
+			return auth.NewAuthProvider(auth.WithHTTPS(session.Username, session.Password)), nil
+		})
+	}
+
+	netrcFile := auth.NetrcPath(s.conf.HomeDir)
+	entry, err := auth.LookupNetrc(netrcFile, host)
+	if err != nil {
+		return nil, "", err
+	}
+	if entry != nil {
+		return s.cachedProvider("netrc://"+host, netrcFile, host, func() (auth.AuthProvider, error) {
+			return auth.NewAuthProvider(auth.WithNetrc(netrcFile, reponame)), nil
+		})
+	}
+
+	if s.credentialHelper == nil {
+		available := auth.HasCredentialHelper()
+		s.credentialHelper = &available
+	}
+	if *s.credentialHelper {
+		// helpers may store credentials per repository path, so the provider is not shared across a host
+		return s.cachedProvider("credential://"+reponame, "git credential helper", reponame, func() (auth.AuthProvider, error) {
+			return auth.NewAuthProvider(auth.WithCredentialHelper(reponame)), nil
+		})
+	}
+
+	return s.httpsProvider, "anonymous", nil
+}
+
+// githubAppProvider shares the provider, and so its cached token, between the repositories of an installation.
+func (s *resolver) githubAppProvider(app auth.GitHubApp, reponame string, source string) (auth.AuthProvider, string, error) {
+	cacheKey := fmt.Sprintf("github-app://%s/%d", app.AppID, app.InstallationID)
+	if app.InstallationID == 0 {
+		// the installation is looked up from the owner of the repository
+		parts := strings.SplitN(reponame, "/", 3)
+		cacheKey = fmt.Sprintf("github-app://%s/%s", app.AppID, strings.Join(parts[:len(parts)-1], "/"))
+	}
+	return s.cachedProvider(cacheKey, source, reponame, func() (auth.AuthProvider, error) {
+		client, err := auth.NewHTTPClient(auth.TransportOptions{
+			CABundle:        s.conf.CABundle,
+			InsecureSkipTLS: s.conf.InsecureSkipTLS,
+		})
+		if err != nil {
+			return nil, err
+		}
+		app.Client = client
+		return auth.NewAuthProvider(auth.WithGitHubApp(app, reponame)), nil
+	})
+}
+
+// sshProviderFor picks the identity of a repository, by order of precedence: the identity file of the dependency,
+// the identity file flag, the identity file configured for the host and ssh-agent. Host keys are checked against
+// the known_hosts of the dependency, the one configured for the host, or the default ones.
+func (s *resolver) sshProviderFor(reponame string, dep sshSettings) (auth.AuthProvider, string, error) {
+	key, cred, _ := config.LookupCredential(s.conf.Credentials, reponame)
+
+	knownHosts, policy := dep.knownHosts, dep.hostKeyPolicy
+	if knownHosts == "" {
+		knownHosts = cred.KnownHosts
+	}
+	if policy == "" {
+		policy = cred.HostKeyPolicy
+	}
+
+	var identityFile, identityPassword, source string
+	switch {
+	case dep.identityFile != "":
+		identityFile, identityPassword = dep.identityFile, s.conf.IdentityPassword
+		source = fmt.Sprintf("identity file %s of the dependency", dep.identityFile)
+	case s.conf.IdentityFile != "":
+		if knownHosts == "" && policy == "" {
+			return s.sshProvider, "identity file flag", nil
+		}
+		identityFile, identityPassword = s.conf.IdentityFile, s.conf.IdentityPassword
+		source = "identity file flag"
+	case cred.IdentityFile != "":
+		identityFile, identityPassword = cred.IdentityFile, cred.IdentityPassword
+		source = fmt.Sprintf("identity file %s of %s in the user config", cred.IdentityFile, key)
+	default:
+		if knownHosts == "" && policy == "" {
+			return s.sshProvider, "ssh-agent", nil
+		}
+		source = "ssh-agent"
+	}
+
+	hostKeyPolicy, err := auth.ParseHostKeyPolicy(policy)
+	if err != nil {
+		return nil, "", err
+	}
+	knownHostsPath := ""
+	if knownHosts != "" {
+		knownHostsPath = s.sshPath(knownHosts)
+	} else if hostKeyPolicy == auth.HostKeyAcceptNew {
+		knownHostsPath = filepath.Join(s.conf.HomeDir, ".ssh", "known_hosts")
+	}
+	if knownHostsPath != "" {
+		source = fmt.Sprintf("%s, known hosts %s (%s)", source, knownHostsPath, hostKeyPolicy)
+	}
+
+	cacheKey := fmt.Sprintf("ssh://%s|%s|%s", identityFile, knownHostsPath, hostKeyPolicy)
+	return s.cachedProvider(cacheKey, source, reponame, func() (auth.AuthProvider, error) {
+		return s.newSSHProvider(identityFile, identityPassword, knownHostsPath, hostKeyPolicy)
+	})
+}
+
+// projectPath resolves a path of protodep.toml: absolute, relative to the home directory with ~/,
+// or relative to the directory of protodep.toml.
+func (s *resolver) projectPath(path string) string {
+	if path == "" || filepath.IsAbs(path) || path == "~" || strings.HasPrefix(path, "~/") {
+		return s.homePath(path)
+	}
+	return filepath.Join(s.conf.TargetDir, path)
+}
+
+// sshPath resolves the path of an ssh flag: absolute, relative to the home directory with ~/, or relative to ~/.ssh.
+// Paths of protodep.toml and of the user config are already absolute or relative to the home directory.
+func (s *resolver) sshPath(path string) string {
+	if filepath.IsAbs(path) || path == "~" || strings.HasPrefix(path, "~/") {
+		return s.homePath(path)
+	}
+	return filepath.Join(s.conf.HomeDir, ".ssh", path)
+}
+
+// homePath resolves a path of the user config: absolute, or relative to the home directory with ~/.
+// config.LoadUserConfig has made the other relative paths relative to the user config file.
+func (s *resolver) homePath(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(s.conf.HomeDir, strings.TrimPrefix(path, "~"))
+	}
+	return path
+}
+
+// urlRewrites flattens the rewrite rules, project rules come first so they win over user rules of the same prefix.
+func urlRewrites(rules ...[]config.URLRewrite) []auth.URLRewrite {
+	rewrites := make([]auth.URLRewrite, 0)
+	for _, rs := range rules {
+		for _, r := range rs {
+			for _, insteadOf := range r.InsteadOf {
+				rewrites = append(rewrites, auth.URLRewrite{
+					Base:      r.Base,
+					InsteadOf: insteadOf,
+					Fallback:  r.Fallback,
+				})
+			}
+		}
+	}
+	return rewrites
+}
+
 func (s *resolver) initAuthProviders() error {
+	if s.conf.CABundle != "" || s.conf.InsecureSkipTLS {
+		if s.conf.InsecureSkipTLS {
+			logger.Warn("**************************************************************************")
+			logger.Warn("TLS certificate verification is DISABLED for https dependencies.")
+			logger.Warn("Anyone on the network path can impersonate git servers and tamper with protos.")
+			logger.Warn("**************************************************************************")
+		}
+		err := auth.InstallHTTPTransport(auth.TransportOptions{
+			CABundle:        s.conf.CABundle,
+			InsecureSkipTLS: s.conf.InsecureSkipTLS,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	s.httpsProvider = auth.NewAuthProvider(auth.WithHTTPS(s.conf.BasicAuthUsername, s.conf.BasicAuthPassword))
+	s.explicitHttps = s.conf.BasicAuthUsername != "" || s.conf.BasicAuthPassword != ""
+
+	sshProvider, err := s.newSSHProvider(s.conf.IdentityFile, s.conf.IdentityPassword, "", auth.HostKeyStrict)
+	if err != nil {
+		return err
+	}
+	s.sshProvider = sshProvider
 
-	if s.conf.IdentityFile == "" && s.conf.IdentityPassword == "" {
-		s.sshProvider = auth.NewAuthProvider()
+	return nil
+}
 
-		return nil
+// newSSHProvider uses the identity file, or ssh-agent. Host keys are checked against knownHosts when given.
+func (s *resolver) newSSHProvider(identityFile string, identityPassword string, knownHosts string, policy auth.HostKeyPolicy) (auth.AuthProvider, error) {
+	opts := make([]auth.AuthOption, 0)
+	if knownHosts != "" {
+		opts = append(opts, auth.WithKnownHosts(knownHosts, policy))
+	}
+	if identityFile == "" && identityPassword == "" {
+		return auth.NewAuthProvider(opts...), nil
 	}
 
-	identifyPath := filepath.Join(s.conf.HomeDir, ".ssh", s.conf.IdentityFile)
+	identifyPath := s.sshPath(identityFile)
 	isSSH, err := isAvailableSSH(identifyPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if isSSH {
-		s.sshProvider = auth.NewAuthProvider(auth.WithPemFile(identifyPath, s.conf.IdentityPassword))
-	} else {
-		logger.Warn("The identity file path has been passed but is not available. Falling back to ssh-agent, the default authentication method.")
-		s.sshProvider = auth.NewAuthProvider()
+		return auth.NewAuthProvider(append(opts, auth.WithPemFile(identifyPath, identityPassword))...), nil
 	}
-
-	return nil
+	logger.Warn("The identity file path has been passed but is not available. Falling back to ssh-agent, the default authentication method.")
+	return auth.NewAuthProvider(opts...), nil
 }
 
 func compileIgnoreToGlob(ignores []string) []glob.Glob {
@@ -241,118 +683,6 @@ func (s *resolver) isMatchPath(protoRootDir string, target string, paths []strin
 	return false
 }
 
-func patchProtoFile(content []byte, filepath string, messageAnnotation string, sources []config.ProtoDepDependency, localBaseDir string) []byte {
-	if len(content) > 0 {
-		lineSeparator := "\n"
-		dirSeparator := "/"
-		packageSeparator := "."
-		javaPackageLinePrefix := "option java_package"
-		importLinePrefix := "import "
-		packageLinePrefix := "package "
-		messageLinePrefix := "message "
-		oneOfLinePrefix := "oneof "
-		enumLinePrefix := "enum "
-		javaClassPrefix := "com."
-		originalPackage := ""
-		totalMessages := 0
-		totalMessagesAlreadyPatched := 0
-		path := strings.Split(filepath, dirSeparator)
-		formattedPath := strings.Join(path[0:(len(path)-1)], packageSeparator)
-
-		lines := strings.Split(string(content), lineSeparator)
-		// patch package, remember original, count messages
-		for i, line := range lines {
-			relativePath := strings.ReplaceAll(formattedPath, dirSeparator, packageSeparator)
-			if strings.HasPrefix(strings.TrimSpace(line), packageLinePrefix) {
-				originalPackage = eliminateCharIfNeeded(line, ";")
-				lines[i] = fmt.Sprintf("%s%s;", packageLinePrefix, relativePath)
-			} else if strings.HasPrefix(strings.TrimSpace(line), javaPackageLinePrefix) {
-				if !(strings.HasPrefix(relativePath, javaClassPrefix)) {
-					relativePath = javaClassPrefix + relativePath
-				}
-				lines[i] = fmt.Sprintf("%s = \"%s\";", javaPackageLinePrefix, relativePath)
-			} else if strings.HasPrefix(strings.TrimSpace(line), messageLinePrefix) {
-				totalMessages++
-			} else if strings.HasPrefix(strings.TrimSpace(line), fmt.Sprintf("option (%s)", messageAnnotation)) {
-				totalMessagesAlreadyPatched++
-			}
-		}
-
-		if (originalPackage != "") && totalMessages > 0 {
-			// patch messages
-			patchedLines := make([]string, len(lines)+totalMessages-totalMessagesAlreadyPatched)
-			totalLinesPatched := 0
-			nestingLevel := 0
-			originalMessage := ""
-			tab := "    "
-			inNestedBlock := false
-			for _, line := range lines {
-				if strings.HasPrefix(strings.TrimSpace(line), importLinePrefix) {
-					targetExists, localTarget := getImportTargetFromRange(line, sources, localBaseDir)
-					if targetExists {
-						line = fmt.Sprintf("%s\"%s\";", importLinePrefix, localTarget)
-					}
-				}
-				if nestingLevel == 0 || !strings.HasPrefix(strings.TrimSpace(line), fmt.Sprintf("option (%s)", messageAnnotation)) {
-					patchedLines[totalLinesPatched] = line
-					totalLinesPatched++
-				}
-				if strings.HasPrefix(strings.TrimSpace(line), messageLinePrefix) {
-					nestingLevel++
-					topLevelMessage := eliminateCharIfNeeded(line, "{")
-					if originalMessage == "" {
-						originalMessage = topLevelMessage
-					} else {
-						originalMessage = originalMessage + packageSeparator + topLevelMessage
-					}
-					patchedLines[totalLinesPatched] = fmt.Sprintf("%soption (%s) = \"%s.%s\";", strings.Repeat(tab, nestingLevel), messageAnnotation, originalPackage, originalMessage)
-					totalLinesPatched++
-				} else if strings.HasPrefix(strings.TrimSpace(line), oneOfLinePrefix) || strings.HasPrefix(strings.TrimSpace(line), enumLinePrefix) {
-					inNestedBlock = true
-				} else if inNestedBlock && strings.HasSuffix(strings.TrimSpace(line), "}") {
-					inNestedBlock = false
-				} else if originalMessage != "" && strings.HasSuffix(strings.TrimSpace(line), "}") {
-					nestingLevel--
-					splitNestedMessage := strings.Split(originalMessage, packageSeparator)
-					if len(splitNestedMessage) > 0 {
-						originalMessage = strings.Join(splitNestedMessage[0:len(splitNestedMessage)-1], packageSeparator)
-					} else {
-						originalMessage = ""
-					}
-				}
-			}
-			return []byte(strings.Join(patchedLines, lineSeparator))
-		}
-
-		return []byte(strings.Join(lines, lineSeparator))
-	}
-	return content
-}
-
-func getImportTargetFromRange(line string, sources []config.ProtoDepDependency, localBaseDir string) (bool, string) {
-	line = strings.ReplaceAll(line, " ", "")
-	line = strings.Trim(line, "import\"")
-	line = strings.Trim(line, "\";")
-	for _, s := range sources {
-		if strings.Contains(s.Target, line) {
-			return true, fmt.Sprintf("%s/%s", strings.TrimPrefix(localBaseDir, "./"), s.Path)
-		}
-	}
-	return false, ""
-}
-
-// eliminates unwanted chars (should they exist) from the subject of a 2-word-expression
-// "package some.thing ;" => "some.thing"
-// "message msg{" => "msg"
-func eliminateCharIfNeeded(expression string, cutset string) string {
-	splittedByNonWhiteSpace := strings.Split(strings.TrimSpace(expression), " ")
-	subject := splittedByNonWhiteSpace[1]
-	if strings.HasSuffix(subject, cutset) {
-		return subject[0 : len(subject)-len(cutset)]
-	}
-	return subject
-}
-
 func writeToml(dest string, input interface{}) error {
 	var buffer bytes.Buffer
 	encoder := toml.NewEncoder(&buffer)
@@ -393,6 +723,11 @@ func writeFileWithDirectory(path string, data []byte, perm os.FileMode) error {
 	return nil
 }
 
+// isLFSPointer reports whether content is a Git LFS pointer file instead of the file itself.
+func isLFSPointer(content []byte) bool {
+	return bytes.HasPrefix(content, []byte("version https://git-lfs.github.com/spec/"))
+}
+
 // isAvailableSSH is Check whether this machine can use git protocol
 func isAvailableSSH(identifyPath string) (bool, error) {
 	if _, err := os.Stat(identifyPath); err != nil {
@@ -0,0 +1,161 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/stormcat24/protodep/pkg/auth"
+	"github.com/stormcat24/protodep/pkg/config"
+	"github.com/stormcat24/protodep/pkg/repository"
+)
+
+// HostStatus describes the credentials used for a host, and whether they grant access to its dependencies.
+type HostStatus struct {
+	Host string
+	// Protocol is https or ssh.
+	Protocol string
+	// Source tells where the credentials come from, e.g. "login session" or "ssh-agent".
+	Source string
+	// Username and Secret are empty for anonymous access. Secret is redacted.
+	Username string
+	Secret   string
+	// AuthErr is set when the credentials can't be loaded, e.g. no ssh-agent is running.
+	AuthErr error
+	// TokenErr is set when the token doesn't have the format expected by the host.
+	TokenErr error
+	// Repository is the dependency probed, ProbeErr the result of the probe.
+	Repository string
+	Probed     bool
+	ProbeErr   error
+}
+
+// AuthStatus reports the credentials selected for the hosts of the dependencies of protodep.toml and of the sessions.
+// With probe, it lists the references of the first dependency of each host to check access.
+func (s *resolver) AuthStatus(probe bool) ([]HostStatus, error) {
+	dependencies := make([]config.ProtoDepDependency, 0)
+
+	protodep, err := config.NewDependency(s.conf.TargetDir, true).Load()
+	switch {
+	case err == nil:
+		s.rewrites = urlRewrites(protodep.URLRewrites, s.conf.URLRewrites)
+		dependencies = protodep.Dependencies
+	case errors.Is(err, fs.ErrNotExist):
+		s.rewrites = urlRewrites(s.conf.URLRewrites)
+	default:
+		return nil, err
+	}
+
+	statuses := make([]HostStatus, 0)
+	seen := make(map[string]int)
+	add := func(reponame string, protocol string, dep sshSettings, repository string) error {
+		provider, source, err := s.selectAuth(reponame, protocol, dep)
+		if err != nil {
+			return err
+		}
+		status := HostStatus{
+			Host:       auth.RepositoryHost(reponame),
+			Protocol:   urlProtocol(provider.GetRepositoryURL(reponame)),
+			Source:     source,
+			Repository: repository,
+		}
+
+		key := status.Host + " " + status.Protocol + " " + source
+		if i, ok := seen[key]; ok {
+			if statuses[i].Repository == "" {
+				statuses[i].Repository = repository
+			}
+			return nil
+		}
+		seen[key] = len(statuses)
+
+		describeAuthMethod(&status, provider)
+		statuses = append(statuses, status)
+		return nil
+	}
+
+	for _, dep := range dependencies {
+		settings := sshSettings{identityFile: dep.IdentityFile, knownHosts: dep.KnownHosts, hostKeyPolicy: dep.HostKeyPolicy}
+		if err := add(dep.Repository(), dep.Protocol, settings, dep.Repository()); err != nil {
+			return nil, err
+		}
+	}
+	hosts := make([]string, 0, len(s.conf.Sessions))
+	for host := range s.conf.Sessions {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		if err := add(host, "https", sshSettings{}, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if probe {
+		for i := range statuses {
+			status := &statuses[i]
+			if status.Repository == "" || status.AuthErr != nil {
+				continue
+			}
+			provider, _, err := s.dependencyAuthProvider(dependencyOf(dependencies, status.Repository))
+			if err != nil {
+				return nil, err
+			}
+			status.Probed = true
+			_, status.ProbeErr = repository.Probe(provider, status.Repository)
+		}
+	}
+
+	return statuses, nil
+}
+
+// describeAuthMethod fills the username and redacted secret of status from the auth method of provider.
+func describeAuthMethod(status *HostStatus, provider auth.AuthProvider) {
+	am, err := provider.AuthMethod()
+	if err != nil {
+		status.AuthErr = err
+		return
+	}
+
+	switch am := am.(type) {
+	case *githttp.BasicAuth:
+		status.Username = am.Username
+		status.Secret = auth.Redact(am.Password)
+		status.TokenErr = auth.ValidateTokenFormat(status.Host, am.Password)
+	case *gitssh.PublicKeys:
+		status.Username = am.User
+		status.Secret = "key " + ssh.FingerprintSHA256(am.Signer.PublicKey())
+	case *gitssh.PublicKeysCallback:
+		status.Username = am.User
+		status.Secret = "keys of ssh-agent"
+	case nil:
+		if status.Source != "anonymous" {
+			// e.g. a credential helper or .netrc without an entry for the repository
+			status.Source = fmt.Sprintf("%s (no credentials)", status.Source)
+		}
+	}
+}
+
+func urlProtocol(url string) string {
+	scheme, _, ok := strings.Cut(url, "://")
+	if !ok {
+		// scp-like git@host:path
+		return "ssh"
+	}
+	return scheme
+}
+
+func dependencyOf(dependencies []config.ProtoDepDependency, reponame string) config.ProtoDepDependency {
+	for _, dep := range dependencies {
+		if dep.Repository() == reponame {
+			return dep
+		}
+	}
+	return config.ProtoDepDependency{Target: reponame}
+}
@@ -0,0 +1,300 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stormcat24/protodep/pkg/config"
+	"github.com/stormcat24/protodep/pkg/logger"
+	"github.com/stormcat24/protodep/pkg/protoparse"
+)
+
+// Unpatch restores the vendored files of the protodep.toml in targetDir, written under outputDir, to the packages,
+// imports and type references they have upstream. The upstream packages come from the patch mapping written by
+// protodep up, or from the annotations smart-patch inserted for files vendored before it was always written. The annotations are removed with the generated
+// annotation protos. Language options stay as smart-patch set them.
+func Unpatch(targetDir string, outputDir string) error {
+	protodep, err := config.NewDependency(targetDir, false).Load()
+	if err != nil {
+		return err
+	}
+	if protodep.PatchAnnotation == "" {
+		return errors.New("smart-patch is not configured, 'patch_package_with_message_annotation' is missing")
+	}
+
+	mappingPath := patchMappingPath(protodep, outputDir)
+	mapping, err := readPatchMapping(mappingPath)
+	switch {
+	case err == nil:
+	case protodep.PatchMapping == "" && errors.Is(err, fs.ErrNotExist):
+		// vendored by a version which didn't keep the mapping, the annotations are all there is
+		mapping = nil
+	case protodep.PatchMapping != "":
+		return fmt.Errorf("read patch_mapping: %w", err)
+	default:
+		return fmt.Errorf("read the patch mapping: %w", err)
+	}
+
+	u := newUnpatcher(protodep, mapping)
+	outdir := filepath.Join(outputDir, protodep.ProtoOutdir)
+	files := make([]vendoredFile, 0)
+	err = filepath.Walk(outdir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".proto") {
+			return nil
+		}
+		rel, err := filepath.Rel(outdir, path)
+		if err != nil {
+			return err
+		}
+		vendoredPath := filepath.ToSlash(filepath.Join(protodep.ProtoOutdir, rel))
+		if u.generated[vendoredPath] {
+			logger.Info("removed %s", path)
+			return os.Remove(path)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		dep, relativeDest := dependencyAt(protodep, vendoredPath)
+		if !dep.PatchEnabled() {
+			return nil
+		}
+		files = append(files, vendoredFile{
+			dep:          dep,
+			source:       path,
+			relativeDest: relativeDest,
+			path:         vendoredPath,
+			outpath:      path,
+			content:      content,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := u.index(files); err != nil {
+		return err
+	}
+	for _, f := range files {
+		content, err := u.unpatch(f)
+		if err != nil {
+			return fmt.Errorf("unpatch %s: %w", f.source, err)
+		}
+		if err := os.WriteFile(f.outpath, content, 0644); err != nil {
+			return err
+		}
+	}
+	if mapping != nil && protodep.PatchMapping == "" {
+		// the internal mapping describes the patched files, which are gone
+		if err := os.Remove(mappingPath); err != nil {
+			return err
+		}
+	}
+	logger.Info("restored %d files in %s", len(files), outdir)
+	return nil
+}
+
+// dependencyAt returns the dependency vendored at path in proto_outdir, with the path of the file in it, or an empty
+// dependency when none matches.
+func dependencyAt(protodep *config.ProtoDep, path string) (config.ProtoDepDependency, string) {
+	found, relativeDest := config.ProtoDepDependency{}, ""
+	longest := -1
+	for _, dep := range protodep.Dependencies {
+		prefix := filepath.ToSlash(filepath.Join(protodep.ProtoOutdir, dep.Path)) + "/"
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			found, relativeDest, longest = dep, strings.TrimPrefix(path, prefix), len(prefix)
+		}
+	}
+	return found, relativeDest
+}
+
+// unpatcher restores vendored files patched by smart-patch, rewriting references like patcher the other way round.
+type unpatcher struct {
+	references *patcher
+	// originalPackages are the upstream packages of the vendored files declaring one, by vendored path.
+	originalPackages map[string]string
+	// mapped are the files of the patch mapping by vendored path, empty without a mapping.
+	mapped map[string]patchedFile
+	// originalImports are the paths the vendored files are imported with upstream, relative to their dependency target.
+	originalImports map[string]string
+	// generated are the import paths of the generated annotation protos.
+	generated map[string]bool
+}
+
+func newUnpatcher(protodep *config.ProtoDep, mapping *patchMapping) *unpatcher {
+	u := &unpatcher{
+		references: &patcher{
+			annotation:        protodep.PatchAnnotation,
+			enumAnnotation:    protodep.PatchEnumAnnotation,
+			serviceAnnotation: protodep.PatchServiceAnnotation,
+		},
+		originalPackages: make(map[string]string),
+		mapped:           make(map[string]patchedFile),
+		originalImports:  make(map[string]string),
+		generated:        make(map[string]bool),
+	}
+	if mapping != nil {
+		for _, f := range mapping.Files {
+			u.mapped[f.Path] = f
+		}
+	}
+	if protodep.PatchAnnotationField != 0 {
+		for _, annotation := range protodep.PatchAnnotations() {
+			file, _ := annotationProto(annotation, protodep.PatchAnnotationField)
+			u.generated[filepath.ToSlash(filepath.Join(protodep.ProtoOutdir, file))] = true
+		}
+	}
+	return u
+}
+
+// index finds the upstream package of each vendored file and records the declarations moving back to it.
+func (u *unpatcher) index(files []vendoredFile) error {
+	u.references.renames = make(map[string][]patchedSymbol)
+	u.references.before = newSymbolTable()
+	u.references.after = newSymbolTable()
+	for _, f := range files {
+		var file *protoparse.File
+		var err error
+		if len(f.content) > 0 {
+			file, err = protoparse.Parse(f.content)
+			if err != nil {
+				return fmt.Errorf("unpatch %s: %w", f.source, err)
+			}
+			if file.Package != nil && config.IsUnpatchedPackage(file.Package.Name) {
+				continue
+			}
+		}
+		if f.relativeDest != "" {
+			u.originalImports[f.path] = f.relativeDest
+		}
+		if file == nil || file.Package == nil {
+			continue
+		}
+		original, ok := u.originalPackage(f.path, file)
+		if !ok {
+			return fmt.Errorf("unpatch %s: the upstream package of %s is neither in the patch mapping nor in an annotation", f.source, file.Package.Name)
+		}
+		u.originalPackages[f.path] = original
+		u.references.rename(file, file.Package.Name, original, f.dep.Target)
+	}
+	return nil
+}
+
+// originalPackage finds the upstream package of the file vendored at path in patch_mapping, or in the annotation of one
+// of its declarations.
+func (u *unpatcher) originalPackage(path string, file *protoparse.File) (string, bool) {
+	if mapped, ok := u.mapped[path]; ok && mapped.Package == file.Package.Name {
+		return mapped.OriginalPackage, true
+	}
+
+	original, found := "", false
+	file.Walk(func(node protoparse.Node, parents []protoparse.Node) bool {
+		if found {
+			return false
+		}
+		name, decls, _, annotation := u.annotated(node)
+		local := qualify(protoparse.Scope(parents), name)
+		for _, decl := range decls {
+			opt, ok := decl.(*protoparse.Option)
+			if !ok || !opt.IsString || !isAnnotation(opt, annotation) {
+				continue
+			}
+			if opt.Value == local {
+				return false
+			}
+			if strings.HasSuffix(opt.Value, "."+local) {
+				original, found = strings.TrimSuffix(opt.Value, "."+local), true
+				return false
+			}
+		}
+		return true
+	})
+	return original, found
+}
+
+// annotated returns the name, body and annotation of a message, or of an enum or a service when they are annotated.
+func (u *unpatcher) annotated(node protoparse.Node) (string, []protoparse.Node, protoparse.Block, string) {
+	p := u.references
+	switch n := node.(type) {
+	case *protoparse.Message:
+		return n.Name, n.Decls, n.Body, p.annotation
+	case *protoparse.Enum:
+		if p.enumAnnotation != "" {
+			return n.Name, n.Decls, n.Body, p.enumAnnotation
+		}
+	case *protoparse.Service:
+		if p.serviceAnnotation != "" {
+			return n.Name, n.Decls, n.Body, p.serviceAnnotation
+		}
+	}
+	return "", nil, protoparse.Block{}, ""
+}
+
+// unpatch moves f back to its upstream package and imports, and removes the annotations.
+func (u *unpatcher) unpatch(f vendoredFile) ([]byte, error) {
+	if len(f.content) == 0 {
+		return f.content, nil
+	}
+	file, err := protoparse.Parse(f.content)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.Package != nil && config.IsUnpatchedPackage(file.Package.Name) {
+		return f.content, nil
+	}
+
+	edits := make([]protoparse.Edit, 0)
+	if original, ok := u.originalPackages[f.path]; ok {
+		if original != file.Package.Name {
+			edits = append(edits, protoparse.Edit{Span: file.Package.NameSpan, Text: original})
+		}
+		edits = append(edits, u.references.patchReferences(file, file.Package.Name, original, f.dep.Target)...)
+	}
+
+	for _, imp := range file.Imports {
+		if u.generated[imp.Path] {
+			edits = append(edits, protoparse.Edit{Span: removalSpan(file.Content, imp.Span)})
+		} else if original, ok := u.originalImports[imp.Path]; ok && original != imp.Path {
+			edits = append(edits, protoparse.Edit{Span: imp.PathSpan, Text: protoparse.Quote(original)})
+		}
+	}
+
+	file.Walk(func(node protoparse.Node, parents []protoparse.Node) bool {
+		_, decls, body, annotation := u.annotated(node)
+		for _, decl := range decls {
+			if opt, ok := decl.(*protoparse.Option); ok && isAnnotation(opt, annotation) {
+				span := removalSpan(file.Content, opt.Span)
+				// an annotation alone in a body on one line, like message Empty { option (a) = "b"; }
+				if len(decls) == 1 && span.Start == body.Open+1 && strings.TrimSpace(string(file.Content[span.End:body.Close])) == "" {
+					span.End = body.Close
+				}
+				edits = append(edits, protoparse.Edit{Span: span})
+			}
+		}
+		return true
+	})
+
+	return protoparse.Apply(f.content, edits)
+}
+
+// removalSpan returns the span removing a declaration: its whole line when alone on it, or the declaration with the
+// space before it.
+func removalSpan(content []byte, span protoparse.Span) protoparse.Span {
+	if line := protoparse.LineSpan(content, span); line != span {
+		return line
+	}
+	if span.Start > 0 && content[span.Start-1] == ' ' {
+		span.Start--
+	}
+	return span
+}
@@ -0,0 +1,191 @@
+package resolver
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/stormcat24/protodep/pkg/config"
+)
+
+const unpatchToml = `proto_outdir = "./proto"
+patch_package_with_message_annotation = "api.derived_from"
+patch_enum_annotation = "api.enum_of"
+patch_service_annotation = "api.service_of"
+patch_annotation_field = 50000
+
+[[dependencies]]
+  target = "github.com/upstream/common"
+  branch = "main"
+  path = "common"
+
+[[dependencies]]
+  target = "github.com/upstream/billing"
+  branch = "main"
+  path = "billing"
+`
+
+func TestUnpatch(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "protodep.toml"), []byte(unpatchToml), 0644))
+	protodep, err := config.NewDependency(dir, false).Load()
+	require.NoError(t, err)
+
+	upstream := map[string]string{
+		"upstream/common/v1/money.proto": `syntax = "proto3";
+package upstream.common.v1;
+
+import "google/protobuf/descriptor.proto";
+
+message Money { string currency = 1; }
+message Empty {}
+extend google.protobuf.FieldOptions { string unit = 50001; }
+`,
+		"google/protobuf/empty.proto": `syntax = "proto3";
+package google.protobuf;
+message Empty {}
+`,
+		"upstream/billing/v1/invoice.proto": `syntax = "proto3";
+
+// invoices
+package upstream.billing.v1;
+
+import "upstream/common/v1/money.proto";
+
+message Invoice {
+  upstream.common.v1.Money total = 1 [(upstream.common.v1.unit) = "EUR"];
+  enum State {
+    STATE_UNKNOWN = 0;
+  }
+  State state = 2;
+}
+
+service Billing {
+  rpc Pay(Invoice) returns (upstream.common.v1.Empty);
+}
+`,
+	}
+	deps := map[string]config.ProtoDepDependency{
+		"upstream/common/v1/money.proto":    protodep.Dependencies[0],
+		"google/protobuf/empty.proto":       protodep.Dependencies[0],
+		"upstream/billing/v1/invoice.proto": protodep.Dependencies[1],
+	}
+
+	vendored := vendorPatched(t, dir, protodep, upstream, deps)
+	require.FileExists(t, filepath.Join(dir, "proto/api/derived_from.proto"))
+
+	require.NoError(t, Unpatch(dir, dir))
+	for _, f := range vendored {
+		content, err := os.ReadFile(f.outpath)
+		require.NoError(t, err)
+		require.Equal(t, upstream[f.source], string(content), f.source)
+	}
+	require.NoFileExists(t, filepath.Join(dir, "proto/api/derived_from.proto"))
+	require.NoFileExists(t, filepath.Join(dir, "proto/api/enum_of.proto"))
+}
+
+// vendorPatched writes the upstream files patched like protodep up does, by their path in the dependency.
+func vendorPatched(t *testing.T, dir string, protodep *config.ProtoDep, upstream map[string]string, deps map[string]config.ProtoDepDependency) []vendoredFile {
+	p, err := newPatcher(protodep, dir)
+	require.NoError(t, err)
+	vendored := make([]vendoredFile, 0)
+	for relativeDest, content := range upstream {
+		dep := deps[relativeDest]
+		path := filepath.ToSlash(filepath.Join(protodep.ProtoOutdir, dep.Path, relativeDest))
+		vendored = append(vendored, vendoredFile{dep: dep, source: relativeDest, relativeDest: "/" + relativeDest, path: path, outpath: filepath.Join(dir, path), content: []byte(content)})
+	}
+	require.NoError(t, p.index(vendored))
+	for _, f := range vendored {
+		content, err := p.patch(f.content, f.path, f.dep)
+		require.NoError(t, err)
+		if f.source != "google/protobuf/empty.proto" {
+			require.NotEqual(t, string(f.content), string(content))
+		}
+		require.NoError(t, writeFileWithDirectory(f.outpath, content, 0644))
+	}
+	require.NoError(t, writeAnnotationProtos(filepath.Join(dir, "proto"), protodep, vendored))
+	require.NoError(t, writePatchMapping(patchMappingPath(protodep, dir), p.mapping()))
+	return vendored
+}
+
+func TestUnpatchWithPatchMapping(t *testing.T) {
+	upstream := map[string]string{
+		"upstream/common/v1/options.proto": `syntax = "proto3";
+package upstream.common.v1;
+import "google/protobuf/descriptor.proto";
+extend google.protobuf.FieldOptions { string unit = 50001; }
+`,
+		"upstream/common/v1/health.proto": `syntax = "proto3";
+package upstream.common.v1;
+service Health {}
+`,
+	}
+	deps := map[string]config.ProtoDepDependency{}
+
+	// files without annotation, declaring extensions or services only, are recovered from the mapping
+	for _, mapping := range []string{"patch_mapping = \"proto/mapping.json\"\n", ""} {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "protodep.toml"), []byte(mapping+unpatchToml), 0644))
+		protodep, err := config.NewDependency(dir, false).Load()
+		require.NoError(t, err)
+		for source := range upstream {
+			deps[source] = protodep.Dependencies[0]
+		}
+		vendored := vendorPatched(t, dir, protodep, upstream, deps)
+
+		require.NoError(t, Unpatch(dir, dir))
+		for _, f := range vendored {
+			content, err := os.ReadFile(f.outpath)
+			require.NoError(t, err)
+			require.Equal(t, upstream[f.source], string(content))
+		}
+	}
+	// the internal mapping is dropped with the patched files
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "protodep.toml"), []byte(unpatchToml), 0644))
+	protodep, err := config.NewDependency(dir, false).Load()
+	require.NoError(t, err)
+	vendorPatched(t, dir, protodep, upstream, deps)
+	require.NoError(t, Unpatch(dir, dir))
+	require.NoFileExists(t, patchMappingPath(protodep, dir))
+
+	// files vendored before the mapping was always written only have their annotations
+	vendorPatched(t, dir, protodep, upstream, deps)
+	require.NoError(t, os.Remove(patchMappingPath(protodep, dir)))
+	require.ErrorContains(t, Unpatch(dir, dir), "is neither in the patch mapping nor in an annotation")
+
+	// a configured mapping must exist
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "protodep.toml"), []byte("patch_mapping = \"proto/mapping.json\"\n"+unpatchToml), 0644))
+	require.ErrorContains(t, Unpatch(dir, dir), "read patch_mapping")
+}
+
+func TestUnpatchWithoutSmartPatch(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "protodep.toml"), []byte("proto_outdir = \"./proto\"\n"), 0644))
+	require.ErrorContains(t, Unpatch(dir, dir), "smart-patch is not configured")
+}
+
+func TestPatchMapping(t *testing.T) {
+	dep := config.ProtoDepDependency{Target: "github.com/upstream/common", Path: "common"}
+	p := &patcher{annotation: "api.derived_from"}
+	require.NoError(t, p.index([]vendoredFile{{
+		dep:     dep,
+		path:    "proto/common/money.proto",
+		content: []byte("package upstream.v1;\nmessage Money { message Unit {} }\nservice Rates {}\nextend Money { string note = 100; }\n"),
+	}}))
+
+	content, err := json.Marshal(p.mapping())
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+  "files": [{"path": "proto/common/money.proto", "package": "proto.common", "original_package": "upstream.v1"}],
+  "symbols": {
+    "proto.common.Money": "upstream.v1.Money",
+    "proto.common.Money.Unit": "upstream.v1.Money.Unit",
+    "proto.common.Rates": "upstream.v1.Rates",
+    "proto.common.note": "upstream.v1.note"
+  }
+}`, string(content))
+}
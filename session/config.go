@@ -3,4 +3,10 @@ package session
 type Config struct {
 	// HomeDir is the home directory, used as root to find ssh identity files.
 	HomeDir string
+
+	// Storage is where Login stores the token. Optional, the system keyring when available, an encrypted file otherwise.
+	Storage Storage
+
+	// Keyring replaces the system keyring. Optional.
+	Keyring Keyring
 }
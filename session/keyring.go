@@ -0,0 +1,114 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// keyringService is the service name tokens are stored under in the system keyring.
+const keyringService = "protodep"
+
+var (
+	ErrKeyringUnavailable = errors.New("no system keyring available")
+	ErrKeyringNotFound    = errors.New("token not found in the system keyring")
+)
+
+// Keyring stores secrets in the secret service of the operating system.
+type Keyring interface {
+	Get(account string) (string, error)
+	Set(account string, secret string) error
+	Delete(account string) error
+}
+
+// SystemKeyring returns the keychain on macOS and the freedesktop secret service (libsecret) elsewhere.
+func SystemKeyring() (Keyring, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		if _, err := exec.LookPath("security"); err != nil {
+			return nil, ErrKeyringUnavailable
+		}
+		return &macKeychain{}, nil
+	case "windows":
+		return nil, ErrKeyringUnavailable
+	default:
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return nil, ErrKeyringUnavailable
+		}
+		// the secret service is reached through the session bus, absent in containers and ssh sessions
+		if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+			return nil, ErrKeyringUnavailable
+		}
+		return &secretService{}, nil
+	}
+}
+
+type macKeychain struct {
+}
+
+func (k *macKeychain) Get(account string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w").Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 44 {
+			return "", ErrKeyringNotFound
+		}
+		return "", fmt.Errorf("read keychain: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (k *macKeychain) Set(account string, secret string) error {
+	// commands are read from stdin so that the secret never shows up in the process list
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		strconv.Quote(keyringService), strconv.Quote(account), strconv.Quote(secret)))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("write keychain: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (k *macKeychain) Delete(account string) error {
+	err := exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", account).Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 44 {
+		return ErrKeyringNotFound
+	}
+	return err
+}
+
+type secretService struct {
+}
+
+func (k *secretService) Get(account string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", keyringService, "account", account).Output()
+	if err != nil {
+		// lookup fails silently when nothing matches, and with a message when the secret service can't be reached
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 && len(bytes.TrimSpace(exitErr.Stderr)) == 0 {
+			return "", ErrKeyringNotFound
+		}
+		return "", fmt.Errorf("read secret service: %w", err)
+	}
+	secret := strings.TrimSpace(string(out))
+	if secret == "" {
+		return "", ErrKeyringNotFound
+	}
+	return secret, nil
+}
+
+func (k *secretService) Set(account string, secret string) error {
+	cmd := exec.Command("secret-tool", "store", "--label=protodep "+account, "service", keyringService, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("write secret service: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (k *secretService) Delete(account string) error {
+	return exec.Command("secret-tool", "clear", "service", keyringService, "account", account).Run()
+}
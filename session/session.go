@@ -7,103 +7,300 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strings"
+
+	"github.com/stormcat24/protodep/pkg/auth"
 )
 
 type Session interface {
-	Login() error
-	Logout() error
-	GetUser() string
-	GetToken() string
+	Login(host string) error
+	// LoginWithToken stores the session of host without prompting, for scripts and CI.
+	LoginWithToken(host string, username string, token string) error
+	Logout(host string) error
+	LogoutAll() error
+	// Hosts returns the hosts with a session, sorted.
+	Hosts() []string
+	GetUser(host string) string
+	GetToken(host string) string
 }
 
+// DefaultHost is the host of `protodep login` without --host, and of sessions created before hosts were supported.
+const DefaultHost = "github.com"
+
 type session struct {
-	conf  *Config
-	User  string
-	Token string
+	conf       *Config
+	configFile string
+	hosts      map[string]*hostSession
+}
+
+// hostSession is the session of one host, as stored in the session file, along with its decrypted token.
+type hostSession struct {
+	sessionData
+	token string
 }
 
 const sessionDataDelimiter = ":"
 const protodepSessionParentDirName = ".protodep"
 const protodepSessionConfigFileName = "config"
 
-var protodepConfigFile string
-
 func New(conf *Config) Session {
 	s := &session{
-		conf:  conf,
-		User:  "",
-		Token: "",
+		conf:       conf,
+		configFile: filepath.Join(conf.HomeDir, protodepSessionParentDirName, protodepSessionConfigFileName),
+		hosts:      make(map[string]*hostSession),
 	}
-	protodepConfigFile = filepath.Join(s.conf.HomeDir, protodepSessionParentDirName, protodepSessionConfigFileName)
 
-	if HasSession() {
-		sessionData, err := ReadSessionData()
-		if err != nil {
+	if s.hasSession() {
+		if err := s.load(); err != nil {
 			fmt.Printf("invalid session detected, please login: %v\n", err)
 		}
-		if len(sessionData) == 0 {
-			fmt.Printf("session not found or empty, please login.")
+	}
+	return s
+}
+
+func (s *session) load() error {
+	content, err := os.ReadFile(s.configFile)
+	if err != nil {
+		return err
+	}
+	file, format, err := parseSessionFile(string(content))
+	if err != nil {
+		return err
+	}
+
+	for host, data := range file.Hosts {
+		hs := &hostSession{sessionData: *data}
+		if err := s.readToken(host, hs); err != nil {
+			fmt.Printf("invalid session for %s, please login: %v\n", host, err)
+			continue
 		}
-		sessionSplit := strings.Split(sessionData, sessionDataDelimiter)
-		if len(sessionSplit) != 2 {
-			fmt.Printf("session corrupted, please login.")
-		} else {
-			s.User = sessionSplit[0]
-			s.Token = sessionSplit[1]
+		s.hosts[host] = hs
+	}
+
+	if format == formatCurrent {
+		return nil
+	}
+
+	// older versions stored a single github.com session in plain text
+	for host, hs := range s.hosts {
+		if err := s.save(host, hs.User, hs.token, StorageAuto); err != nil {
+			return fmt.Errorf("migrate session: %w", err)
 		}
+		fmt.Printf("migrated session of %s to %s\n", host, storageDescription(s.hosts[host].Storage))
 	}
-	return s
+	return nil
+}
+
+func (s *session) readToken(host string, hs *hostSession) error {
+	switch hs.Storage {
+	case StorageKeyring:
+		kr, err := s.keyring()
+		if err != nil {
+			return err
+		}
+		hs.token, err = kr.Get(keyringAccount(host, hs.User))
+		return err
+	case StorageFile:
+		token, err := decryptToken(hs.Salt, hs.Token)
+		if err != nil {
+			return err
+		}
+		hs.token = token
+		return nil
+	case "":
+		// plain text
+		hs.token = hs.Token
+		return nil
+	default:
+		return fmt.Errorf("unknown session storage %q", hs.Storage)
+	}
+}
+
+// save stores the session of host in the requested storage, then writes the session file.
+func (s *session) save(host string, username string, token string, storage Storage) error {
+	hs := &hostSession{sessionData: sessionData{User: username}, token: token}
+	if storage == "" {
+		storage = StorageAuto
+	}
+
+	if storage == StorageAuto || storage == StorageKeyring {
+		err := s.saveToKeyring(keyringAccount(host, username), token)
+		if err == nil {
+			hs.Storage = StorageKeyring
+		} else if storage == StorageKeyring {
+			return err
+		}
+	}
+
+	if hs.Storage == "" {
+		salt, sealed, err := encryptToken(token)
+		if err != nil {
+			return err
+		}
+		hs.Storage, hs.Salt, hs.Token = StorageFile, salt, sealed
+	}
+
+	s.hosts[host] = hs
+	return s.write()
+}
+
+func (s *session) write() error {
+	if len(s.hosts) == 0 {
+		if err := os.Remove(s.configFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	file := &sessionFile{Hosts: make(map[string]*sessionData, len(s.hosts))}
+	for host, hs := range s.hosts {
+		data := hs.sessionData
+		file.Hosts[host] = &data
+	}
+	return writeSessionFile(s.configFile, file)
+}
+
+func (s *session) saveToKeyring(account string, token string) error {
+	kr, err := s.keyring()
+	if err != nil {
+		return err
+	}
+	return kr.Set(account, token)
+}
+
+func (s *session) keyring() (Keyring, error) {
+	if s.conf.Keyring != nil {
+		return s.conf.Keyring, nil
+	}
+	return SystemKeyring()
+}
+
+// keyringAccount names the keyring entry of a session, so that sessions of several hosts can use the same username.
+func keyringAccount(host string, username string) string {
+	return username + "@" + host
 }
 
-func (s *session) GetUser() string {
-	return s.User
+func (s *session) Hosts() []string {
+	hosts := make([]string, 0, len(s.hosts))
+	for host := range s.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
 }
 
-func (s *session) GetToken() string {
-	return s.Token
+func (s *session) GetUser(host string) string {
+	if hs, ok := s.hosts[host]; ok {
+		return hs.User
+	}
+	return ""
 }
 
-func (s *session) Login() error {
-	fmt.Println("Logging in...")
-	username := promptUser()
+func (s *session) GetToken(host string) string {
+	if hs, ok := s.hosts[host]; ok {
+		return hs.token
+	}
+	return ""
+}
+
+func (s *session) Login(host string) error {
+	if host == "" {
+		host = DefaultHost
+	}
+	fmt.Printf("Logging in to %s...\n", host)
+	username := promptUser(host)
 	if len(username) == 0 {
 		return errors.New("user not provided, input aborted")
 	}
-	token := promptToken()
+	token := promptToken(host)
 	if len(token) == 0 {
 		return errors.New("token not provided, input aborted")
 	}
-	content := username + sessionDataDelimiter + token
-	if err := WriteSessionData(content); err != nil {
+	return s.login(host, username, token)
+}
+
+func (s *session) LoginWithToken(host string, username string, token string) error {
+	if host == "" {
+		host = DefaultHost
+	}
+	if err := validateUser(username); err != nil {
+		return err
+	}
+	token = strings.TrimSpace(token)
+	if err := tokenValidator(host)(token); err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+	fmt.Printf("Logging in to %s as %s...\n", host, username)
+	return s.login(host, username, token)
+}
+
+func (s *session) login(host string, username string, token string) error {
+	// a token of the previous user would stay in the keyring otherwise
+	if previous, ok := s.hosts[host]; ok && previous.Storage == StorageKeyring && previous.User != username {
+		s.deleteFromKeyring(keyringAccount(host, previous.User))
+	}
+	if err := s.save(host, username, token, s.conf.Storage); err != nil {
 		return err
 	}
-	fmt.Println("OK")
+	fmt.Printf("OK, token stored in %s\n", storageDescription(s.hosts[host].Storage))
 	return nil
 }
 
-func (s *session) Logout() error {
-	fmt.Println("Logging out...")
-	if HasSession() {
-		if err := os.Remove(protodepConfigFile); err != nil {
+func storageDescription(storage Storage) string {
+	if storage == StorageKeyring {
+		return "the system keyring"
+	}
+	return "an encrypted file"
+}
+
+func (s *session) Logout(host string) error {
+	if host == "" {
+		host = DefaultHost
+	}
+	fmt.Printf("Logging out from %s...\n", host)
+	hs, ok := s.hosts[host]
+	if !ok {
+		fmt.Printf("session not found for %s\n", host)
+		return nil
+	}
+
+	if hs.Storage == StorageKeyring {
+		s.deleteFromKeyring(keyringAccount(host, hs.User))
+	}
+	delete(s.hosts, host)
+	if err := s.write(); err != nil {
+		return err
+	}
+	fmt.Println("Bye!")
+	return nil
+}
+
+func (s *session) LogoutAll() error {
+	fmt.Println("Logging out from all hosts...")
+	for host, hs := range s.hosts {
+		if hs.Storage == StorageKeyring {
+			s.deleteFromKeyring(keyringAccount(host, hs.User))
+		}
+	}
+	s.hosts = make(map[string]*hostSession)
+	if s.hasSession() {
+		if err := os.Remove(s.configFile); err != nil {
 			return err
 		}
-		fmt.Println("Bye!")
-	} else {
-		fmt.Printf("session not found")
 	}
+	fmt.Println("Bye!")
 	return nil
 }
 
-func promptUser() string {
+func promptUser(host string) string {
 	var username string
 	u, err := user.Current()
 	if err == nil {
 		username = u.Username
 	}
 	prompt := promptui.Prompt{
-		Label:     "what's your github user?",
+		Label:     fmt.Sprintf("what's your %s user?", host),
 		Validate:  validateUser,
 		Default:   username,
 		AllowEdit: true,
@@ -117,8 +314,18 @@ func promptUser() string {
 	return result
 }
 
-func promptToken() string {
-	fmt.Printf(`
+// tokenValidator returns the validation of tokens of host, GitHub tokens have a known format.
+func tokenValidator(host string) func(string) error {
+	if host == DefaultHost {
+		return validateToken
+	}
+	return validateNotEmpty
+}
+
+func promptToken(host string) string {
+	switch {
+	case host == DefaultHost:
+		fmt.Printf(`
 a personal access token is required to allow protodep access to dependency sources.
 
 generate your personal token here: https://github.com/settings/tokens
@@ -127,9 +334,24 @@ generate your personal token here: https://github.com/settings/tokens
 
 read more about personal access tokens: https://docs.github.com/en/authentication/keeping-your-account-and-data-secure/creating-a-personal-access-token
 `)
+	case strings.Contains(host, "gitlab"):
+		fmt.Printf(`
+a personal access token is required to allow protodep access to dependency sources.
+
+generate your personal token here: https://%s/-/user_settings/personal_access_tokens
+- make sure you enable the 'read_repository' scope.
+`, host)
+	default:
+		fmt.Printf(`
+a personal access token (or application password) of %s is required to allow protodep access to dependency sources.
+- GitHub Enterprise: https://%s/settings/tokens, with the 'repo' scope.
+- Gitea: https://%s/user/settings/applications, with read access to repositories.
+`, host, host, host)
+	}
+
 	prompt := promptui.Prompt{
 		Label:    "personal access token",
-		Validate: validateToken,
+		Validate: tokenValidator(host),
 		Mask:     '*',
 	}
 
@@ -148,41 +370,33 @@ func validateUser(input string) error {
 	return nil
 }
 
+func validateNotEmpty(input string) error {
+	if strings.TrimSpace(input) == "" {
+		return errors.New("token must not be empty")
+	}
+	if strings.ContainsAny(strings.TrimSpace(input), " \t\r\n") {
+		return errors.New("token must be a single word")
+	}
+	return nil
+}
+
 func validateToken(input string) error {
-	var githubTokenPattern string = "^(ghp_[a-zA-Z0-9]{36}|github_pat_[a-zA-Z0-9]{22}_[a-zA-Z0-9]{59}|v[0-9]\\.[0-9a-f]{40})$"
-	_, err := regexp.MatchString(githubTokenPattern, input)
-	return err
+	return auth.ValidateTokenFormat(DefaultHost, input)
 }
 
-func HasSession() bool {
-	if _, err := os.Stat(protodepConfigFile); err == nil {
+func (s *session) hasSession() bool {
+	if _, err := os.Stat(s.configFile); err == nil {
 		return true
 	}
 	return false
 }
 
-func ReadSessionData() (string, error) {
-	content, err := os.ReadFile(protodepConfigFile)
+func (s *session) deleteFromKeyring(account string) {
+	kr, err := s.keyring()
 	if err != nil {
-		return "", err
+		return
 	}
-	return strings.TrimSpace(string(content)), nil
-}
-
-func WriteSessionData(content string) error {
-	dir := filepath.Dir(protodepConfigFile)
-	if err := os.MkdirAll(dir, 0777); err != nil {
-		return fmt.Errorf("create directory %s: %w", dir, err)
-	}
-	f, createErr := os.Create(protodepConfigFile)
-	if createErr != nil {
-		return createErr
+	if err := kr.Delete(account); err != nil && err != ErrKeyringNotFound {
+		fmt.Printf("failed to remove the token of %s from the system keyring: %v\n", account, err)
 	}
-	defer f.Close()
-
-	_, writeErr := f.WriteString(content + "\n")
-	if writeErr != nil {
-		return writeErr
-	}
-	return nil
 }
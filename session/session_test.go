@@ -0,0 +1,145 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memoryKeyring map[string]string
+
+func (k memoryKeyring) Get(account string) (string, error) {
+	secret, ok := k[account]
+	if !ok {
+		return "", ErrKeyringNotFound
+	}
+	return secret, nil
+}
+
+func (k memoryKeyring) Set(account string, secret string) error {
+	k[account] = secret
+	return nil
+}
+
+func (k memoryKeyring) Delete(account string) error {
+	if _, ok := k[account]; !ok {
+		return ErrKeyringNotFound
+	}
+	delete(k, account)
+	return nil
+}
+
+type unavailableKeyring struct {
+	memoryKeyring
+}
+
+func (k unavailableKeyring) Set(account string, secret string) error {
+	return ErrKeyringUnavailable
+}
+
+func TestSaveToKeyring(t *testing.T) {
+	homeDir := t.TempDir()
+	kr := memoryKeyring{}
+
+	s := New(&Config{HomeDir: homeDir, Keyring: kr}).(*session)
+	require.NoError(t, s.save("github.com", "octocat", "ghp_secret", StorageAuto))
+	require.NoError(t, s.save("gitlab.example.com", "octocat", "glpat_secret", StorageAuto))
+	require.Equal(t, StorageKeyring, s.hosts["github.com"].Storage)
+	require.Equal(t, "ghp_secret", kr["octocat@github.com"])
+	require.Equal(t, "glpat_secret", kr["octocat@gitlab.example.com"])
+
+	content, err := os.ReadFile(s.configFile)
+	require.NoError(t, err)
+	require.NotContains(t, string(content), "ghp_secret")
+
+	reloaded := New(&Config{HomeDir: homeDir, Keyring: kr})
+	require.Equal(t, []string{"github.com", "gitlab.example.com"}, reloaded.Hosts())
+	require.Equal(t, "octocat", reloaded.GetUser("github.com"))
+	require.Equal(t, "ghp_secret", reloaded.GetToken("github.com"))
+	require.Equal(t, "glpat_secret", reloaded.GetToken("gitlab.example.com"))
+
+	require.NoError(t, reloaded.Logout("gitlab.example.com"))
+	require.Equal(t, memoryKeyring{"octocat@github.com": "ghp_secret"}, kr)
+	require.Equal(t, []string{"github.com"}, New(&Config{HomeDir: homeDir, Keyring: kr}).Hosts())
+
+	require.NoError(t, reloaded.LogoutAll())
+	require.Empty(t, kr)
+	require.NoFileExists(t, s.configFile)
+}
+
+func TestSaveToEncryptedFile(t *testing.T) {
+	homeDir := t.TempDir()
+	kr := unavailableKeyring{memoryKeyring{}}
+
+	s := New(&Config{HomeDir: homeDir, Keyring: kr}).(*session)
+	err := s.save("github.com", "octocat", "ghp_secret", StorageKeyring)
+	require.ErrorIs(t, err, ErrKeyringUnavailable)
+
+	require.NoError(t, s.save("github.com", "octocat", "ghp_secret", StorageAuto))
+	require.Equal(t, StorageFile, s.hosts["github.com"].Storage)
+
+	info, err := os.Stat(s.configFile)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	content, err := os.ReadFile(s.configFile)
+	require.NoError(t, err)
+	require.NotContains(t, string(content), "ghp_secret")
+
+	reloaded := New(&Config{HomeDir: homeDir, Keyring: kr})
+	require.Equal(t, "octocat", reloaded.GetUser("github.com"))
+	require.Equal(t, "ghp_secret", reloaded.GetToken("github.com"))
+	require.Empty(t, reloaded.GetToken("gitlab.example.com"))
+}
+
+func TestMigrateLegacySession(t *testing.T) {
+	homeDir := t.TempDir()
+	configFile := filepath.Join(homeDir, protodepSessionParentDirName, protodepSessionConfigFileName)
+	require.NoError(t, os.MkdirAll(filepath.Dir(configFile), 0777))
+	require.NoError(t, os.WriteFile(configFile, []byte("octocat:ghp_secret\n"), 0644))
+
+	s := New(&Config{HomeDir: homeDir, Keyring: unavailableKeyring{memoryKeyring{}}})
+	require.Equal(t, []string{DefaultHost}, s.Hosts())
+	require.Equal(t, "octocat", s.GetUser(DefaultHost))
+	require.Equal(t, "ghp_secret", s.GetToken(DefaultHost))
+
+	content, err := os.ReadFile(configFile)
+	require.NoError(t, err)
+	require.NotContains(t, string(content), "ghp_secret")
+	info, err := os.Stat(configFile)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestDecryptTokenTampered(t *testing.T) {
+	salt, sealed, err := encryptToken("ghp_secret")
+	require.NoError(t, err)
+
+	token, err := decryptToken(salt, sealed)
+	require.NoError(t, err)
+	require.Equal(t, "ghp_secret", token)
+
+	otherSalt, _, err := encryptToken("ghp_secret")
+	require.NoError(t, err)
+	_, err = decryptToken(otherSalt, sealed)
+	require.Error(t, err)
+}
+
+func TestLoginWithToken(t *testing.T) {
+	homeDir := t.TempDir()
+	kr := memoryKeyring{}
+	s := New(&Config{HomeDir: homeDir, Keyring: kr})
+
+	require.Error(t, s.LoginWithToken("gitlab.example.com", "ci-bot", " \n"))
+	require.Error(t, s.LoginWithToken("gitlab.example.com", "", "glpat-token"))
+	require.Error(t, s.LoginWithToken("gitlab.example.com", "ci-bot", "two words"))
+	require.Empty(t, s.Hosts())
+
+	require.NoError(t, s.LoginWithToken("gitlab.example.com", "ci-bot", "glpat-token\n"))
+	require.Equal(t, "glpat-token", kr["ci-bot@gitlab.example.com"])
+
+	reloaded := New(&Config{HomeDir: homeDir, Keyring: kr})
+	require.Equal(t, "ci-bot", reloaded.GetUser("gitlab.example.com"))
+	require.Equal(t, "glpat-token", reloaded.GetToken("gitlab.example.com"))
+}
@@ -0,0 +1,191 @@
+package session
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Storage is where the token of a session is kept.
+type Storage string
+
+const (
+	// StorageAuto uses the system keyring when available, the encrypted file otherwise.
+	StorageAuto    Storage = "auto"
+	StorageKeyring Storage = "keyring"
+	StorageFile    Storage = "file"
+)
+
+func ParseStorage(s string) (Storage, error) {
+	switch Storage(s) {
+	case "", StorageAuto:
+		return StorageAuto, nil
+	case StorageKeyring, StorageFile:
+		return Storage(s), nil
+	default:
+		return "", fmt.Errorf("unknown storage %q (auto, keyring or file)", s)
+	}
+}
+
+// sessionData is the session of a host. The token is only there, encrypted, with the file storage.
+type sessionData struct {
+	User    string  `toml:"user"`
+	Storage Storage `toml:"storage"`
+	Salt    string  `toml:"salt,omitempty"`
+	Token   string  `toml:"token,omitempty"`
+}
+
+// sessionFile is the content of the session file, sessions are keyed by host.
+type sessionFile struct {
+	Hosts map[string]*sessionData `toml:"hosts"`
+}
+
+type sessionFormat int
+
+const (
+	formatCurrent sessionFormat = iota
+	// formatPlainText is "user:token" for github.com.
+	formatPlainText
+)
+
+// parseSessionFile reads the session file, in its current format or the one of an older version.
+func parseSessionFile(content string) (*sessionFile, sessionFormat, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, 0, errors.New("session is empty")
+	}
+
+	if !strings.Contains(content, "=") {
+		split := strings.Split(content, sessionDataDelimiter)
+		if len(split) != 2 {
+			return nil, 0, errors.New("session corrupted")
+		}
+		return &sessionFile{Hosts: map[string]*sessionData{
+			DefaultHost: {User: split[0], Token: split[1]},
+		}}, formatPlainText, nil
+	}
+
+	file := &sessionFile{}
+	if _, err := toml.Decode(content, file); err != nil {
+		return nil, 0, fmt.Errorf("decode session: %w", err)
+	}
+	return file, formatCurrent, nil
+}
+
+func writeSessionFile(path string, file *sessionFile) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("create directory %s: %w", dir, err)
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(file); err != nil {
+		return err
+	}
+
+	// write next to the session then rename, so the file never exists with wider permissions or partial content
+	tmp, err := os.CreateTemp(dir, ".config-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// localKey derives the file encryption key from the salt, the machine and the user.
+// It keeps the token unreadable once the file is copied elsewhere, it does not protect it from the user's own processes.
+func localKey(salt []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("protodep-session\x00"))
+	h.Write(salt)
+	h.Write([]byte(machineID()))
+	if u, err := user.Current(); err == nil {
+		h.Write([]byte(u.Uid))
+		h.Write([]byte(u.Username))
+	}
+	return h.Sum(nil)
+}
+
+func machineID() string {
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if content, err := os.ReadFile(path); err == nil {
+			return strings.TrimSpace(string(content))
+		}
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+func encryptToken(token string) (salt string, sealed string, err error) {
+	rawSalt := make([]byte, 16)
+	if _, err := rand.Read(rawSalt); err != nil {
+		return "", "", err
+	}
+
+	gcm, err := newGCM(rawSalt)
+	if err != nil {
+		return "", "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+
+	ciphertext := gcm.Seal(nonce, nonce, []byte(token), nil)
+	return base64.StdEncoding.EncodeToString(rawSalt), base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+func decryptToken(salt string, sealed string) (string, error) {
+	rawSalt, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return "", fmt.Errorf("decode salt: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", fmt.Errorf("decode token: %w", err)
+	}
+
+	gcm, err := newGCM(rawSalt)
+	if err != nil {
+		return "", err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return "", errors.New("token corrupted")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	token, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("token can't be decrypted, the session was created by another user or machine")
+	}
+	return string(token), nil
+}
+
+func newGCM(salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(localKey(salt))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}